@@ -0,0 +1,125 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"image/color"
+	"unsafe"
+)
+
+// HasAlpha reports whether img has an alpha channel
+func (img *Image) HasAlpha() bool {
+	return img.Format().Channels() == 4
+}
+
+// IsOpaque reports whether all of img's pixels are opaque, scanning its alpha
+// channel if it has one
+func (img *Image) IsOpaque() (opaque bool, err error) {
+	img.m.RLock()
+	defer img.m.RUnlock()
+	defer recoverWithError(&err)
+
+	if img.iplImage == nil {
+		return false, ErrReleased
+	}
+
+	if img.iplImage.nChannels != 4 {
+		return true, nil
+	}
+	return C.prismIsOpaque(img.iplImage) != 0, nil
+}
+
+// Flatten composites img onto a background of the given color, whose alpha is
+// ignored, and drops its alpha channel. Images without alpha are unchanged.
+func (img *Image) Flatten(background color.Color) (err error) {
+	img.m.Lock()
+	defer img.m.Unlock()
+	defer recoverWithError(&err)
+
+	if img.iplImage == nil {
+		return ErrReleased
+	}
+
+	if img.iplImage.nChannels == 4 {
+		img.replace(flattened(img.iplImage, background))
+	} else {
+		img.detach()
+	}
+	return nil
+}
+
+// Flattened returns a copy of img composited onto a background of the given
+// color, without alpha
+func (img *Image) Flattened(background color.Color) (*Image, error) {
+	return img.derive(func(src *C.IplImage) (*C.IplImage, error) {
+		if src.nChannels != 4 {
+			return cloned(src), nil
+		}
+		return flattened(src, background), nil
+	})
+}
+
+// RemoveAlpha drops img's alpha channel, leaving the color of transparent
+// pixels as it was. Use Flatten to composite them onto a background instead.
+func (img *Image) RemoveAlpha() (err error) {
+	img.m.Lock()
+	defer img.m.Unlock()
+	defer recoverWithError(&err)
+
+	if img.iplImage == nil {
+		return ErrReleased
+	}
+
+	if img.iplImage.nChannels == 4 {
+		size := C.cvGetSize(unsafe.Pointer(img.iplImage))
+		dst := C.cvCreateImage(size, img.iplImage.depth, 3)
+		C.cvCvtColor(unsafe.Pointer(img.iplImage), unsafe.Pointer(dst), C.CV_BGRA2BGR)
+		img.replace(dst)
+	} else {
+		img.detach()
+	}
+	return nil
+}
+
+func flattened(iplImage *C.IplImage, background color.Color) *C.IplImage {
+	c := color.NRGBA64Model.Convert(background).(color.NRGBA64)
+	r, g, b := c.R, c.G, c.B
+	if iplImage.depth != C.IPL_DEPTH_16U {
+		r, g, b = r>>8, g>>8, b>>8
+	}
+
+	return C.prismFlatten(iplImage, C.int(b), C.int(g), C.int(r))
+}
+
+// lockJPEGSource returns img, or a copy of it flattened onto background, or
+// white if nil, if it has alpha, which JPEG lacks, read locked for encoding.
+// unlock releases the lock and any copy.
+func lockJPEGSource(img *Image, background color.Color) (src *Image, unlock func(), err error) {
+	src = img
+	release := func() {}
+	if img.HasAlpha() {
+		if background == nil {
+			background = color.White
+		}
+		if src, err = img.Flattened(background); err != nil {
+			return nil, nil, err
+		}
+		release = src.Release
+	}
+
+	src.m.RLock()
+	if src.iplImage == nil {
+		src.m.RUnlock()
+		release()
+		return nil, nil, ErrReleased
+	}
+
+	return src, func() {
+		src.m.RUnlock()
+		release()
+	}, nil
+}
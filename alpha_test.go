@@ -0,0 +1,104 @@
+package prism
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// width x height image, transparent except for an opaque red left half
+func halfRedImg(width, height int) *Image {
+	return fillRect(uniformImg(width, height, 4, color.Transparent), image.Rect(0, 0, width/2, height), red)
+}
+
+func TestHasAlpha(t *testing.T) {
+	assert.True(t, halfRedImg(4, 4).HasAlpha())
+	assert.True(t, testImg("rgba64.png").HasAlpha())
+	assert.False(t, lenna.HasAlpha())
+	assert.False(t, testImg("gray.jpg").HasAlpha())
+}
+
+func TestIsOpaque(t *testing.T) {
+	img := halfRedImg(4, 4)
+
+	opaque, err := img.IsOpaque()
+	assert.Nil(t, err)
+	assert.False(t, opaque)
+
+	fillRect(img, img.Bounds(), blue)
+	opaque, _ = img.IsOpaque()
+	assert.True(t, opaque)
+
+	opaque, _ = lenna.IsOpaque()
+	assert.True(t, opaque)
+
+	opaque, _ = testImg("rgba64.png").IsOpaque()
+	assert.False(t, opaque)
+
+	img.Release()
+	_, err = img.IsOpaque()
+	assert.Equal(t, ErrReleased, err)
+}
+
+func TestFlatten(t *testing.T) {
+	img := halfRedImg(4, 4)
+	img.Set(3, 0, color.NRGBA{0, 0, 255, 128})
+
+	err := img.Flatten(color.White)
+	assert.Nil(t, err)
+	assert.Equal(t, PixelFormatBGR, img.Format())
+	assert.Equal(t, color.NRGBA{255, 0, 0, 255}, img.At(0, 0))
+	assert.Equal(t, color.NRGBA{255, 255, 255, 255}, img.At(3, 3))
+	assert.Equal(t, color.NRGBA{127, 127, 255, 255}, img.At(3, 0))
+
+	wide := testImg("rgba64.png")
+	flat, err := wide.Flattened(color.Black)
+	assert.Nil(t, err)
+	assert.Equal(t, PixelFormatBGRA64, wide.Format())
+	assert.Equal(t, PixelFormatBGR48, flat.Format())
+
+	// premultiplied samples are composited onto black
+	r, g, b, _ := wide.At(10, 10).RGBA()
+	c := flat.At(10, 10).(color.NRGBA64)
+	assert.InDelta(t, r, c.R, 1)
+	assert.InDelta(t, g, c.G, 1)
+	assert.InDelta(t, b, c.B, 1)
+	assert.Equal(t, uint16(0xffff), c.A)
+
+	assert.Nil(t, lenna.Copy().Flatten(color.White))
+}
+
+func TestRemoveAlpha(t *testing.T) {
+	img := halfRedImg(4, 4)
+	img.Set(3, 0, color.NRGBA{0, 0, 255, 0})
+
+	err := img.RemoveAlpha()
+	assert.Nil(t, err)
+	assert.Equal(t, PixelFormatBGR, img.Format())
+	assert.Equal(t, color.NRGBA{255, 0, 0, 255}, img.At(0, 0))
+	assert.Equal(t, color.NRGBA{0, 0, 255, 255}, img.At(3, 0))
+}
+
+func TestEncodeJPEGBackground(t *testing.T) {
+	for _, background := range []color.Color{nil, color.White, blue} {
+		var buf bytes.Buffer
+		enc := NewEncoder()
+		enc.Background = background
+		err := enc.EncodeJPEG(&buf, halfRedImg(64, 64), 100)
+		assert.Nil(t, err)
+
+		if background == nil {
+			background = color.White
+		}
+		decoded, _ := jpeg.Decode(&buf)
+		r, g, b, _ := decoded.At(60, 32).RGBA()
+		er, eg, eb, _ := background.RGBA()
+		assert.InDelta(t, er>>8, r>>8, 4)
+		assert.InDelta(t, eg>>8, g>>8, 4)
+		assert.InDelta(t, eb>>8, b>>8, 4)
+	}
+}
@@ -0,0 +1,54 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+// size that Analyze scales images down to fit within, so that scores are
+// comparable across resolutions and match how photos are usually viewed
+const analysisSize = 1024
+
+// Analysis scores the technical quality of an image's luma, as measured by
+// Analyze
+type Analysis struct {
+	// Sharpness is the variance of the Laplacian; photos scoring below about
+	// 100 tend to look blurry
+	Sharpness float64
+
+	// Brightness is the mean luma, from 0 (black) to 1 (white)
+	Brightness float64
+
+	// Shadows and Highlights are the fractions of pixels clipped to black and
+	// to white, signs of under- and overexposure
+	Shadows    float64
+	Highlights float64
+
+	// Noise is an estimate of the standard deviation of noise, from 0 - 255
+	Noise float64
+}
+
+// Analyze scores the sharpness, exposure and noise of img, scaled down to fit
+// within 1024x1024 if larger, as a hint for photos that are blurry, badly
+// exposed or noisy. Alpha is ignored.
+func (img *Image) Analyze() (a Analysis, err error) {
+	img.m.RLock()
+	defer img.m.RUnlock()
+	defer recoverWithError(&err)
+
+	if img.iplImage == nil {
+		return a, ErrReleased
+	}
+
+	var sharpness, brightness, shadows, highlights, noise C.double
+	C.prismAnalyze(img.iplImage, analysisSize, &sharpness, &brightness, &shadows, &highlights, &noise)
+
+	return Analysis{
+		Sharpness:  float64(sharpness),
+		Brightness: float64(brightness),
+		Shadows:    float64(shadows),
+		Highlights: float64(highlights),
+		Noise:      float64(noise),
+	}, nil
+}
@@ -0,0 +1,66 @@
+package prism
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeSharpness(t *testing.T) {
+	img := testImg("lenna.png")
+	small, _ := img.Resized(64, 64)
+	blurry, _ := small.Resized(512, 512)
+
+	sharp, err := img.Analyze()
+	assert.Nil(t, err)
+	soft, _ := blurry.Analyze()
+
+	assert.True(t, sharp.Sharpness > 100)
+	assert.True(t, soft.Sharpness < sharp.Sharpness/4)
+}
+
+func TestAnalyzeExposure(t *testing.T) {
+	img := fillRect(uniformImg(100, 100, 3, color.White), image.Rect(0, 0, 25, 100), color.Black)
+
+	a, err := img.Analyze()
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.75, a.Brightness, 0.001)
+	assert.InDelta(t, 0.25, a.Shadows, 0.001)
+	assert.InDelta(t, 0.75, a.Highlights, 0.001)
+
+	gray := uniformImg(100, 100, 1, color.Gray{128})
+
+	a, _ = gray.Analyze()
+	assert.InDelta(t, 128.0/255, a.Brightness, 0.001)
+	assert.Equal(t, 0.0, a.Sharpness)
+	assert.Equal(t, 0.0, a.Shadows+a.Highlights)
+	assert.Equal(t, 0.0, a.Noise)
+}
+
+func TestAnalyzeNoise(t *testing.T) {
+	img, _ := NewImage(200, 200, 1, 8)
+	r := rand.New(rand.NewSource(1))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			img.Set(x, y, color.Gray{uint8(128 + r.NormFloat64()*10)})
+		}
+	}
+
+	a, err := img.Analyze()
+	assert.Nil(t, err)
+	assert.InDelta(t, 10, a.Noise, 1.5)
+
+	img.Release()
+	_, err = img.Analyze()
+	assert.Equal(t, ErrReleased, err)
+}
+
+func BenchmarkAnalyze(b *testing.B) {
+	img := testImg("lenna.jpg")
+	for n := 0; n < b.N; n++ {
+		img.Analyze()
+	}
+}
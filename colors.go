@@ -0,0 +1,223 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"errors"
+	"image/color"
+	"sort"
+	"unsafe"
+)
+
+// size that DominantColors scales images down to before clustering
+const dominantColorsSize = 64
+
+// Histogram returns the number of pixels with each value, from 0 to 255, for
+// each channel of img, in R, G, B(, A) order, or a single gray channel. 16-bit
+// samples are counted by their high 8 bits.
+func (img *Image) Histogram() (histogram [][256]int, err error) {
+	img.m.RLock()
+	defer img.m.RUnlock()
+	defer recoverWithError(&err)
+
+	if img.iplImage == nil {
+		return nil, ErrReleased
+	}
+
+	channels := int(img.iplImage.nChannels)
+	counts := make([]C.int, channels*256)
+	C.prismHistogram(img.iplImage, &counts[0])
+
+	histogram = make([][256]int, channels)
+	for c := 0; c < channels; c++ {
+		// BGR(A) to RGB(A)
+		i := c
+		if channels >= 3 && c < 3 {
+			i = 2 - c
+		}
+		for v := 0; v < 256; v++ {
+			histogram[i][v] = int(counts[c*256+v])
+		}
+	}
+
+	return histogram, nil
+}
+
+// AverageColor returns the mean color of img's pixels, as a color.NRGBA. The
+// colors of images with alpha are weighted by it, so that transparent pixels
+// do not count; if all are transparent, the result is color.NRGBA{}.
+func (img *Image) AverageColor() (c color.Color, err error) {
+	img.m.RLock()
+	defer img.m.RUnlock()
+	defer recoverWithError(&err)
+
+	if img.iplImage == nil {
+		return nil, ErrReleased
+	}
+
+	var avg C.CvScalar
+	if img.iplImage.nChannels == 4 {
+		C.prismAverageColor(img.iplImage, &avg.val[0])
+	} else {
+		avg = C.cvAvg(unsafe.Pointer(img.iplImage), nil)
+	}
+
+	scale := 1.0
+	if img.iplImage.depth == C.IPL_DEPTH_16U {
+		scale = 1.0 / 257
+	}
+	sample := func(i int) uint8 {
+		return uint8(float64(avg.val[i])*scale + 0.5)
+	}
+
+	switch img.iplImage.nChannels {
+	case 1:
+		return color.NRGBA{sample(0), sample(0), sample(0), 255}, nil
+	case 4:
+		a := sample(3)
+		if a == 0 {
+			return color.NRGBA{}, nil
+		}
+		return color.NRGBA{sample(2), sample(1), sample(0), a}, nil
+	default:
+		return color.NRGBA{sample(2), sample(1), sample(0), 255}, nil
+	}
+}
+
+// DominantColors returns up to k colors representative of img, as
+// color.NRGBA, the most common first. They are found by k-means clustering of
+// the opaque pixels of a copy of img scaled down to 64x64, so an image with
+// fewer than k distinct colors may return fewer.
+func (img *Image) DominantColors(k int) ([]color.Color, error) {
+	if k < 1 {
+		return nil, errors.New("Number of colors must be positive")
+	}
+
+	small, err := img.Fitted(dominantColorsSize, dominantColorsSize)
+	if err != nil {
+		return nil, err
+	}
+	defer small.Release()
+
+	nrgba := small.ToNRGBA()
+	if nrgba == nil {
+		return nil, ErrReleased
+	}
+
+	var points []rgbPoint
+	for i := 0; i < len(nrgba.Pix); i += 4 {
+		if nrgba.Pix[i+3] >= 128 {
+			points = append(points, rgbPoint{float64(nrgba.Pix[i]), float64(nrgba.Pix[i+1]), float64(nrgba.Pix[i+2])})
+		}
+	}
+
+	clusters := kmeans(points, k)
+
+	colors := make([]color.Color, len(clusters))
+	for i, cluster := range clusters {
+		colors[i] = color.NRGBA{
+			uint8(cluster.center[0] + 0.5),
+			uint8(cluster.center[1] + 0.5),
+			uint8(cluster.center[2] + 0.5),
+			255,
+		}
+	}
+	return colors, nil
+}
+
+type rgbPoint [3]float64
+
+func (p rgbPoint) luma() float64 {
+	return 0.299*p[0] + 0.587*p[1] + 0.114*p[2]
+}
+
+func (p rgbPoint) distance(q rgbPoint) float64 {
+	d0, d1, d2 := p[0]-q[0], p[1]-q[1], p[2]-q[2]
+	return d0*d0 + d1*d1 + d2*d2
+}
+
+type cluster struct {
+	center rgbPoint
+	size   int
+}
+
+type byClusterSize []cluster
+
+func (c byClusterSize) Len() int           { return len(c) }
+func (c byClusterSize) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byClusterSize) Less(i, j int) bool { return c[i].size > c[j].size }
+
+// kmeans clusters points into at most k non-empty clusters, largest first.
+// Centers start at quantiles of luma, which keeps results deterministic.
+func kmeans(points []rgbPoint, k int) []cluster {
+	if len(points) == 0 {
+		return nil
+	}
+
+	sorted := make([]rgbPoint, len(points))
+	copy(sorted, points)
+	sort.Sort(byLuma(sorted))
+
+	clusters := make([]cluster, k)
+	for i := range clusters {
+		clusters[i].center = sorted[(2*i+1)*len(sorted)/(2*k)]
+	}
+
+	assignments := make([]int, len(points))
+	for iteration := 0; iteration < 20; iteration++ {
+		changed := false
+		for i, p := range points {
+			nearest := 0
+			for j := range clusters {
+				if p.distance(clusters[j].center) < p.distance(clusters[nearest].center) {
+					nearest = j
+				}
+			}
+			if nearest != assignments[i] || iteration == 0 {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+
+		if !changed {
+			break
+		}
+
+		sums := make([]rgbPoint, k)
+		for i := range clusters {
+			clusters[i].size = 0
+		}
+		for i, p := range points {
+			j := assignments[i]
+			sums[j][0] += p[0]
+			sums[j][1] += p[1]
+			sums[j][2] += p[2]
+			clusters[j].size++
+		}
+		for j := range clusters {
+			if n := float64(clusters[j].size); n > 0 {
+				clusters[j].center = rgbPoint{sums[j][0] / n, sums[j][1] / n, sums[j][2] / n}
+			}
+		}
+	}
+
+	sort.Stable(byClusterSize(clusters))
+
+	nonEmpty := clusters[:0]
+	for _, c := range clusters {
+		if c.size > 0 {
+			nonEmpty = append(nonEmpty, c)
+		}
+	}
+	return nonEmpty
+}
+
+type byLuma []rgbPoint
+
+func (p byLuma) Len() int           { return len(p) }
+func (p byLuma) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byLuma) Less(i, j int) bool { return p[i].luma() < p[j].luma() }
@@ -0,0 +1,80 @@
+package prism
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	red  = color.NRGBA{255, 0, 0, 255}
+	blue = color.NRGBA{0, 0, 255, 255}
+)
+
+// width x height image, red on the left 3/4 and blue on the right
+func redBlueImg(width, height int) *Image {
+	return fillRect(uniformImg(width, height, 3, red), image.Rect(width*3/4, 0, width, height), blue)
+}
+
+func TestHistogram(t *testing.T) {
+	img := redBlueImg(4, 4)
+
+	histogram, err := img.Histogram()
+	assert.Nil(t, err)
+	assert.Len(t, histogram, 3)
+
+	assert.Equal(t, 12, histogram[0][255])
+	assert.Equal(t, 4, histogram[0][0])
+	assert.Equal(t, 16, histogram[1][0])
+	assert.Equal(t, 4, histogram[2][255])
+	assert.Equal(t, 12, histogram[2][0])
+
+	gray, _ := testImg("gray.jpg").Histogram()
+	assert.Len(t, gray, 1)
+}
+
+func TestAverageColor(t *testing.T) {
+	c, err := redBlueImg(4, 4).AverageColor()
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{191, 0, 64, 255}, c)
+
+	img, _ := NewImage(2, 2, 1, 16)
+	img.Set(0, 0, color.Gray16{0xffff})
+	c, _ = img.AverageColor()
+	assert.Equal(t, color.NRGBA{64, 64, 64, 255}, c)
+
+	// the transparent black half does not darken the red half
+	c, _ = halfRedImg(4, 4).AverageColor()
+	assert.Equal(t, color.NRGBA{255, 0, 0, 128}, c)
+
+	img, _ = NewImage(2, 2, 4, 8)
+	c, _ = img.AverageColor()
+	assert.Equal(t, color.NRGBA{}, c)
+}
+
+func TestDominantColors(t *testing.T) {
+	img := redBlueImg(100, 100)
+
+	colors, err := img.DominantColors(3)
+	assert.Nil(t, err)
+	assert.Equal(t, []color.Color{red, blue}, colors)
+
+	colors, _ = testImg("lenna.jpg").DominantColors(5)
+	assert.Len(t, colors, 5)
+
+	_, err = img.DominantColors(0)
+	assert.NotNil(t, err)
+
+	img.Release()
+	_, err = img.DominantColors(3)
+	assert.Equal(t, ErrReleased, err)
+}
+
+func BenchmarkDominantColors(b *testing.B) {
+	img := testImg("lenna.jpg")
+	for n := 0; n < b.N; n++ {
+		img.DominantColors(5)
+	}
+}
@@ -0,0 +1,84 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"errors"
+	"math"
+	"unsafe"
+)
+
+// Metrics measure how similar two images, or one of their channels, are
+type Metrics struct {
+	MSE  float64 // mean squared error, on a 0 - 255 scale
+	PSNR float64 // peak signal-to-noise ratio in decibels, +Inf if identical
+	SSIM float64 // mean structural similarity, 1 if identical
+}
+
+// Comparison is the result of Compare: metrics over the whole image, which
+// average those of each channel
+type Comparison struct {
+	Metrics
+	Channels []Metrics // in R, G, B(, A) order, or a single gray channel
+}
+
+// Compare measures the similarity of two images of the same size. Images with
+// different channel counts are compared on those they have in common: a color
+// image is reduced to its luma to compare it with a gray one, and alpha is
+// ignored unless both images have it. 16-bit images are compared at 8 bits.
+func Compare(a, b *Image) (comparison *Comparison, err error) {
+	// lock in a consistent order, so concurrent comparisons cannot deadlock
+	first, second := a, b
+	if uintptr(unsafe.Pointer(a)) > uintptr(unsafe.Pointer(b)) {
+		first, second = b, a
+	}
+
+	first.m.RLock()
+	defer first.m.RUnlock()
+	if second != first {
+		second.m.RLock()
+		defer second.m.RUnlock()
+	}
+	defer recoverWithError(&err)
+
+	if a.iplImage == nil || b.iplImage == nil {
+		return nil, ErrReleased
+	}
+
+	if a.bounds().Size() != b.bounds().Size() {
+		return nil, errors.New("Images have different sizes")
+	}
+
+	var mse, ssim [4]C.double
+	channels := int(C.prismCompare(a.iplImage, b.iplImage, &mse[0], &ssim[0]))
+
+	comparison = &Comparison{Channels: make([]Metrics, channels)}
+	for i := 0; i < channels; i++ {
+		metrics := Metrics{MSE: float64(mse[i]), SSIM: float64(ssim[i])}
+		metrics.PSNR = psnr(metrics.MSE)
+
+		// BGR(A) to RGB(A)
+		j := i
+		if channels >= 3 && i < 3 {
+			j = 2 - i
+		}
+		comparison.Channels[j] = metrics
+
+		comparison.MSE += metrics.MSE / float64(channels)
+		comparison.SSIM += metrics.SSIM / float64(channels)
+	}
+	comparison.PSNR = psnr(comparison.MSE)
+
+	return comparison, nil
+}
+
+func psnr(mse float64) float64 {
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
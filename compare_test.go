@@ -0,0 +1,77 @@
+package prism
+
+import (
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareIdentical(t *testing.T) {
+	img := testImg("lenna.jpg")
+	c, err := Compare(img, img.Copy())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, c.MSE)
+	assert.True(t, math.IsInf(c.PSNR, 1))
+	assert.InDelta(t, 1, c.SSIM, 1e-6)
+	assert.Len(t, c.Channels, 3)
+
+	c, err = Compare(img, img)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, c.MSE)
+}
+
+func TestCompareChannels(t *testing.T) {
+	a, _ := NewImage(4, 4, 3, 8)
+	b, _ := NewImage(4, 4, 3, 8)
+	b.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+
+	c, err := Compare(a, b)
+	assert.Nil(t, err)
+	assert.InDelta(t, 255*255/16.0, c.Channels[0].MSE, 1e-6)
+	assert.Equal(t, 0.0, c.Channels[1].MSE)
+	assert.Equal(t, 0.0, c.Channels[2].MSE)
+	assert.InDelta(t, 255*255/48.0, c.MSE, 1e-6)
+	assert.InDelta(t, 10*math.Log10(48), c.PSNR, 1e-6)
+}
+
+func TestCompareEncoded(t *testing.T) {
+	c, err := Compare(testImg("lenna.png"), testImg("lenna.jpg"))
+
+	assert.Nil(t, err)
+	assert.True(t, c.PSNR > 30)
+	assert.True(t, c.SSIM > 0.9 && c.SSIM < 1)
+}
+
+func TestCompareGray(t *testing.T) {
+	img := testImg("lenna.png")
+	gray, _ := FromImage(img.ToGray())
+
+	c, err := Compare(img, gray)
+	assert.Nil(t, err)
+	assert.Len(t, c.Channels, 1)
+	assert.True(t, c.PSNR > 40)
+}
+
+func TestCompareErrors(t *testing.T) {
+	img := testImg("lenna.jpg")
+	small, _ := img.Resized(100, 100)
+
+	_, err := Compare(img, small)
+	assert.NotNil(t, err)
+
+	small.Release()
+	_, err = Compare(img, small)
+	assert.Equal(t, ErrReleased, err)
+}
+
+func BenchmarkCompare(b *testing.B) {
+	a := testImg("lenna.png")
+	c := testImg("lenna.jpg")
+
+	for n := 0; n < b.N; n++ {
+		Compare(a, c)
+	}
+}
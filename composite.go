@@ -0,0 +1,161 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"unsafe"
+)
+
+// BlendMode combines the colors of an overlay with those beneath it
+type BlendMode string
+
+const (
+	BlendNormal   BlendMode = "normal"   // the overlay's color
+	BlendMultiply BlendMode = "multiply" // darkens by the overlay's color
+	BlendScreen   BlendMode = "screen"   // lightens by the overlay's color
+	BlendOverlay  BlendMode = "overlay"  // multiplies dark areas and screens light ones
+)
+
+var blendModes = map[BlendMode]C.int{
+	"":            C.PRISM_BLEND_NORMAL,
+	BlendNormal:   C.PRISM_BLEND_NORMAL,
+	BlendMultiply: C.PRISM_BLEND_MULTIPLY,
+	BlendScreen:   C.PRISM_BLEND_SCREEN,
+	BlendOverlay:  C.PRISM_BLEND_OVERLAY,
+}
+
+// CompositeOptions control how Composite places and blends an overlay
+type CompositeOptions struct {
+	// Gravity places the overlay within the image; empty for GravityCenter
+	Gravity Gravity
+
+	// Offset moves the overlay away from the edges Gravity places it against,
+	// or right and down from the center
+	Offset image.Point
+
+	// Opacity scales the overlay's alpha, from 0 - 1. The zero value is fully
+	// opaque, as with 1.
+	Opacity float64
+
+	// Blend combines the overlay's colors with the image's before alpha
+	// blending; empty for BlendNormal
+	Blend BlendMode
+
+	// Tile repeats the overlay across the whole image, from where it is placed
+	Tile bool
+
+	// Scale, if positive, resizes the overlay to fit within Scale times the
+	// size of the image, preserving its aspect ratio
+	Scale float64
+}
+
+// Composite draws overlay onto img, alpha blending it over img's pixels and
+// clipping it to img's bounds. Overlays without alpha are opaque. Onto images
+// with alpha, the result is as if both were composited onto the same
+// background; onto gray images, the overlay's luma is drawn. The overlay is
+// blended with 8-bit precision, so 16-bit overlays lose precision even onto
+// 16-bit images.
+func (img *Image) Composite(overlay *Image, opts CompositeOptions) (err error) {
+	mode, ok := blendModes[opts.Blend]
+	if !ok {
+		return fmt.Errorf("Invalid blend mode: %q", opts.Blend)
+	}
+	if _, ok := gravityOffsets[opts.Gravity]; !ok && opts.Gravity != "" {
+		return fmt.Errorf("Invalid gravity: %q", opts.Gravity)
+	}
+	if opts.Opacity < 0 || opts.Opacity > 1 {
+		return fmt.Errorf("Invalid opacity: %v", opts.Opacity)
+	}
+	if opts.Scale < 0 {
+		return fmt.Errorf("Invalid scale: %v", opts.Scale)
+	}
+	opacity := opts.Opacity
+	if opacity == 0 {
+		opacity = 1
+	}
+
+	// scale, or copy an overlay that is img itself, so that the overlay is
+	// never locked along with img
+	src := overlay
+	if opts.Scale > 0 {
+		bounds := img.Bounds()
+		if src, err = scaledOverlay(overlay, bounds, opts.Scale); err != nil {
+			return err
+		}
+	} else if overlay == img {
+		src = img.Copy()
+	}
+	if src != overlay {
+		defer src.Release()
+	}
+
+	// lock in a consistent order, so concurrent composites cannot deadlock
+	if uintptr(unsafe.Pointer(src)) < uintptr(unsafe.Pointer(img)) {
+		src.m.RLock()
+		defer src.m.RUnlock()
+		img.m.Lock()
+		defer img.m.Unlock()
+	} else {
+		img.m.Lock()
+		defer img.m.Unlock()
+		src.m.RLock()
+		defer src.m.RUnlock()
+	}
+	defer recoverWithError(&err)
+
+	if img.iplImage == nil || src.iplImage == nil {
+		return ErrReleased
+	}
+
+	bounds, size := img.pixelBounds(), src.bounds().Size()
+	at := opts.Gravity.Position(bounds, size).Min.Add(gravityOffset(opts.Gravity, opts.Offset))
+
+	if !opts.Tile {
+		C.prismComposite(img.iplImage, src.iplImage, C.int(at.X), C.int(at.Y), C.double(opacity), mode)
+		return nil
+	}
+
+	// start from a tile at or before the top left corner
+	at.X -= (at.X + size.X - 1) / size.X * size.X
+	at.Y -= (at.Y + size.Y - 1) / size.Y * size.Y
+	C.prismCompositeTiled(img.iplImage, src.iplImage, C.int(at.X), C.int(at.Y), C.double(opacity), mode)
+
+	return nil
+}
+
+// gravityOffset points offset inward from the edges g places a region against
+func gravityOffset(g Gravity, offset image.Point) image.Point {
+	sign := gravityOffsets[g]
+	if sign.X == 1 {
+		offset.X = -offset.X
+	}
+	if sign.Y == 1 {
+		offset.Y = -offset.Y
+	}
+	return offset
+}
+
+// scaledOverlay returns a copy of overlay resized to fit within scale times
+// the size of bounds
+func scaledOverlay(overlay *Image, bounds image.Rectangle, scale float64) (*Image, error) {
+	size := overlay.Bounds().Size()
+	if size.X == 0 || size.Y == 0 {
+		return nil, ErrReleased
+	}
+
+	ratio := math.Min(
+		scale*float64(bounds.Dx())/float64(size.X),
+		scale*float64(bounds.Dy())/float64(size.Y),
+	)
+	width := int(math.Max(1, float64(size.X)*ratio+0.5))
+	height := int(math.Max(1, float64(size.Y)*ratio+0.5))
+
+	return overlay.Resized(width, height)
+}
@@ -0,0 +1,105 @@
+package prism
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComposite(t *testing.T) {
+	base := uniformImg(10, 10, 3, blue)
+	overlay := uniformImg(2, 2, 4, red)
+
+	err := base.Composite(overlay, CompositeOptions{Gravity: GravitySouthEast, Offset: image.Pt(1, 1)})
+	assert.Nil(t, err)
+	assert.Equal(t, red, base.At(7, 7))
+	assert.Equal(t, red, base.At(8, 8))
+	assert.Equal(t, blue, base.At(9, 9))
+	assert.Equal(t, blue, base.At(6, 6))
+
+	base.Composite(overlay, CompositeOptions{Gravity: GravityNorthWest, Offset: image.Pt(-1, -1)})
+	assert.Equal(t, red, base.At(0, 0))
+	assert.Equal(t, blue, base.At(1, 1))
+}
+
+func TestCompositeOpacity(t *testing.T) {
+	base := uniformImg(4, 4, 3, color.Black)
+	overlay := uniformImg(4, 4, 4, color.NRGBA{255, 255, 255, 128})
+
+	base.Composite(overlay, CompositeOptions{Opacity: 0.5})
+	assert.Equal(t, color.NRGBA{64, 64, 64, 255}, base.At(2, 2))
+
+	// onto transparency, the overlay's alpha is kept
+	clear, _ := NewImage(4, 4, 4, 8)
+	clear.Composite(overlay, CompositeOptions{})
+	assert.Equal(t, color.NRGBA{255, 255, 255, 128}, clear.At(2, 2))
+
+	gray := uniformImg(4, 4, 1, color.Gray{0})
+	gray.Composite(uniformImg(4, 4, 3, red), CompositeOptions{})
+	assert.Equal(t, color.Gray{76}, gray.At(0, 0))
+}
+
+func TestCompositeBlend(t *testing.T) {
+	gray := color.NRGBA{128, 128, 128, 255}
+
+	tests := []struct {
+		mode BlendMode
+		want color.NRGBA
+	}{
+		{BlendNormal, gray},
+		{BlendMultiply, color.NRGBA{128, 0, 0, 255}},
+		{BlendScreen, color.NRGBA{255, 128, 128, 255}},
+		{BlendOverlay, color.NRGBA{255, 0, 0, 255}},
+	}
+
+	for _, test := range tests {
+		base := uniformImg(2, 2, 3, red)
+		err := base.Composite(uniformImg(2, 2, 3, gray), CompositeOptions{Blend: test.mode})
+		assert.Nil(t, err, string(test.mode))
+		assert.Equal(t, test.want, base.At(0, 0), string(test.mode))
+	}
+}
+
+func TestCompositeTileAndScale(t *testing.T) {
+	base := uniformImg(10, 10, 3, blue)
+	overlay := fillRect(uniformImg(4, 4, 4, color.Transparent), image.Rect(0, 0, 2, 2), red)
+
+	base.Composite(overlay, CompositeOptions{Gravity: GravityNorthWest, Offset: image.Pt(1, 1), Tile: true})
+	for _, p := range []image.Point{{1, 1}, {5, 5}, {9, 1}, {1, 9}} {
+		assert.Equal(t, red, base.At(p.X, p.Y), p.String())
+	}
+	for _, p := range []image.Point{{0, 0}, {3, 3}, {4, 1}, {8, 8}} {
+		assert.Equal(t, blue, base.At(p.X, p.Y), p.String())
+	}
+
+	base = uniformImg(20, 10, 3, blue)
+	base.Composite(uniformImg(2, 2, 3, red), CompositeOptions{Scale: 0.5})
+	assert.Equal(t, red, base.At(7, 2))
+	assert.Equal(t, red, base.At(11, 6))
+	assert.Equal(t, blue, base.At(6, 2))
+	assert.Equal(t, blue, base.At(12, 6))
+	assert.Equal(t, blue, base.At(7, 7))
+}
+
+func TestCompositeErrors(t *testing.T) {
+	base := uniformImg(4, 4, 3, blue)
+	overlay := uniformImg(2, 2, 4, red)
+
+	assert.NotNil(t, base.Composite(overlay, CompositeOptions{Blend: "dodge"}))
+	assert.NotNil(t, base.Composite(overlay, CompositeOptions{Gravity: "up"}))
+	assert.NotNil(t, base.Composite(overlay, CompositeOptions{Opacity: 2}))
+	assert.Nil(t, base.Composite(base, CompositeOptions{}))
+
+	overlay.Release()
+	assert.Equal(t, ErrReleased, base.Composite(overlay, CompositeOptions{}))
+}
+
+func BenchmarkComposite(b *testing.B) {
+	img := testImg("lenna.jpg")
+	overlay := uniformImg(128, 128, 4, color.NRGBA{255, 255, 255, 128})
+	for n := 0; n < b.N; n++ {
+		img.Composite(overlay, CompositeOptions{Gravity: GravitySouthEast, Offset: image.Pt(16, 16)})
+	}
+}
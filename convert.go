@@ -0,0 +1,157 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"unsafe"
+)
+
+// ToNRGBA copies img into a new *image.NRGBA, swapping OpenCV's BGR(A) channel
+// order to RGBA in a single pass. 16-bit samples are truncated to 8 bits and
+// images without an alpha channel are fully opaque. It returns nil if img has
+// been released.
+func (img *Image) ToNRGBA() *image.NRGBA {
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	if img.iplImage == nil {
+		return nil
+	}
+
+	dst := image.NewNRGBA(img.bounds())
+	if len(dst.Pix) > 0 {
+		C.prismToNRGBA(img.iplImage, (*C.uchar)(unsafe.Pointer(&dst.Pix[0])), C.int(dst.Stride))
+	}
+	return dst
+}
+
+// ToGray copies img into a new *image.Gray. Color images are converted using
+// the same luma weights as color.GrayModel, 16-bit samples are truncated to 8
+// bits and alpha is ignored. It returns nil if img has been released.
+func (img *Image) ToGray() *image.Gray {
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	if img.iplImage == nil {
+		return nil
+	}
+
+	dst := image.NewGray(img.bounds())
+	if len(dst.Pix) > 0 {
+		C.prismToGray(img.iplImage, (*C.uchar)(unsafe.Pointer(&dst.Pix[0])), C.int(dst.Stride))
+	}
+	return dst
+}
+
+// FromImage copies m into a new Image. *image.NRGBA, *image.RGBA, *image.Gray
+// and *image.YCbCr are converted in a single pass in C; other image types are
+// first drawn onto an *image.NRGBA, losing any precision beyond 8 bits.
+func FromImage(m image.Image) (img *Image, err error) {
+	defer recoverWithError(&err)
+
+	if src, ok := m.(*Image); ok {
+		if img = src.Copy(); img.iplImage == nil {
+			return nil, ErrReleased
+		}
+		return img, nil
+	}
+
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("Unable to convert empty image")
+	}
+
+	switch m := m.(type) {
+	case *image.NRGBA:
+		return FromBuffer(m.Pix[m.PixOffset(bounds.Min.X, bounds.Min.Y):], width, height, m.Stride, PixelFormatRGBA)
+	case *image.Gray:
+		return FromBuffer(m.Pix[m.PixOffset(bounds.Min.X, bounds.Min.Y):], width, height, m.Stride, PixelFormatGray)
+	case *image.RGBA:
+		pix := m.Pix[m.PixOffset(bounds.Min.X, bounds.Min.Y):]
+		iplImage := C.prismFromRGBA(
+			(*C.uchar)(unsafe.Pointer(&pix[0])),
+			C.int(width), C.int(height), C.int(m.Stride),
+		)
+		return newImage(iplImage, nil), nil
+	case *image.YCbCr:
+		hShift, vShift, ok := chromaShift(m.SubsampleRatio)
+		if !ok {
+			break
+		}
+
+		y := m.Y[m.YOffset(bounds.Min.X, bounds.Min.Y):]
+		cOffset := m.COffset(bounds.Min.X, bounds.Min.Y)
+		iplImage := C.prismFromYCbCr(
+			(*C.uchar)(unsafe.Pointer(&y[0])), C.int(m.YStride),
+			(*C.uchar)(unsafe.Pointer(&m.Cb[cOffset])),
+			(*C.uchar)(unsafe.Pointer(&m.Cr[cOffset])), C.int(m.CStride),
+			C.int(width), C.int(height), C.int(bounds.Min.X), C.int(bounds.Min.Y),
+			C.int(hShift), C.int(vShift),
+		)
+		return newImage(iplImage, nil), nil
+	}
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Bounds(), m, bounds.Min, draw.Src)
+	return FromBuffer(nrgba.Pix, width, height, nrgba.Stride, PixelFormatRGBA)
+}
+
+// FromBuffer copies width x height pixels from pix, with rows stride bytes
+// apart, into a new Image. 16-bit formats are read in native byte order, and
+// RGB(A) formats are swizzled to OpenCV's BGR(A) order.
+func FromBuffer(pix []byte, width, height, stride int, format PixelFormat) (img *Image, err error) {
+	defer recoverWithError(&err)
+
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("Invalid image size: %d x %d", width, height)
+	}
+
+	rowSize := width * format.Channels() * format.Depth() / 8
+	if stride < rowSize || len(pix) < (height-1)*stride+rowSize {
+		return nil, errors.New("Pixel buffer is too small for image size")
+	}
+
+	swapRB := format == PixelFormatRGB || format == PixelFormatRGBA
+	iplImage := C.prismFromBuffer(
+		(*C.uchar)(unsafe.Pointer(&pix[0])),
+		C.int(width), C.int(height), C.int(stride),
+		C.int(format.Channels()), C.int(format.Depth()), C.int(boolToInt(swapRB)),
+	)
+
+	return newImage(iplImage, nil), nil
+}
+
+// horizontal and vertical chroma subsampling, as powers of two
+func chromaShift(ratio image.YCbCrSubsampleRatio) (h, v int, ok bool) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio444:
+		return 0, 0, true
+	case image.YCbCrSubsampleRatio422:
+		return 1, 0, true
+	case image.YCbCrSubsampleRatio420:
+		return 1, 1, true
+	case image.YCbCrSubsampleRatio440:
+		return 0, 1, true
+	case image.YCbCrSubsampleRatio411:
+		return 2, 0, true
+	case image.YCbCrSubsampleRatio410:
+		return 2, 1, true
+	}
+	return 0, 0, false
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
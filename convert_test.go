@@ -0,0 +1,143 @@
+package prism
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToNRGBA(t *testing.T) {
+	img := testImg("lenna.png")
+	nrgba := img.ToNRGBA()
+
+	assert.Equal(t, img.Bounds(), nrgba.Bounds())
+	for _, p := range [][2]int{{0, 0}, {100, 200}, {511, 511}} {
+		assert.Equal(t, img.At(p[0], p[1]), nrgba.At(p[0], p[1]))
+	}
+}
+
+func TestToNRGBAAlpha(t *testing.T) {
+	img := testImg("mlk.png")
+	nrgba := img.ToNRGBA()
+
+	for _, p := range [][2]int{{0, 0}, {262, 252}, {524, 503}} {
+		assert.Equal(t, img.At(p[0], p[1]), nrgba.At(p[0], p[1]))
+	}
+}
+
+func TestToNRGBA16(t *testing.T) {
+	img := testImg("rgb48.png")
+	nrgba := img.ToNRGBA()
+
+	for _, p := range [][2]int{{0, 0}, {300, 300}, {599, 599}} {
+		assert.Equal(t, color.NRGBAModel.Convert(img.At(p[0], p[1])), nrgba.At(p[0], p[1]))
+	}
+}
+
+func TestToGray(t *testing.T) {
+	img := testImg("gray.jpg")
+	gray := img.ToGray()
+
+	assert.Equal(t, img.Bounds(), gray.Bounds())
+	for _, p := range [][2]int{{0, 0}, {123, 40}, {246, 78}} {
+		assert.Equal(t, img.At(p[0], p[1]), gray.At(p[0], p[1]))
+	}
+}
+
+func TestToGrayColor(t *testing.T) {
+	img := testImg("lenna.png")
+	gray := img.ToGray()
+
+	for _, p := range [][2]int{{0, 0}, {100, 200}, {511, 511}} {
+		assert.Equal(t, color.GrayModel.Convert(img.At(p[0], p[1])), gray.At(p[0], p[1]))
+	}
+}
+
+func TestFromImageNRGBA(t *testing.T) {
+	src := testImg("mlk.png").ToNRGBA()
+	img, err := FromImage(src)
+
+	assert.Nil(t, err)
+	assert.Equal(t, PixelFormatBGRA, img.Format())
+	assert.Equal(t, src.Pix, img.ToNRGBA().Pix)
+}
+
+func TestFromImageStdlib(t *testing.T) {
+	for _, name := range []string{"lenna.jpg", "lenna.png", "gray.jpg"} {
+		src := stdlibImg(name)
+		img, err := FromImage(src)
+		assert.Nil(t, err)
+		assert.Equal(t, src.Bounds(), img.Bounds())
+
+		for _, p := range [][2]int{{0, 0}, {30, 60}, {src.Bounds().Dx() - 1, src.Bounds().Dy() - 1}} {
+			assert.Equal(t, img.ColorModel().Convert(src.At(p[0], p[1])), img.At(p[0], p[1]), name)
+		}
+	}
+}
+
+func TestFromImageSubImage(t *testing.T) {
+	src := stdlibImg("lenna.jpg").(*image.YCbCr).SubImage(image.Rect(101, 51, 301, 151))
+	img, err := FromImage(src)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, img.Bounds().Dx())
+	assert.Equal(t, 100, img.Bounds().Dy())
+	assert.Equal(t, color.NRGBAModel.Convert(src.At(101, 51)), img.At(0, 0))
+	assert.Equal(t, color.NRGBAModel.Convert(src.At(300, 150)), img.At(199, 99))
+}
+
+func TestFromBuffer(t *testing.T) {
+	pix := []byte{
+		255, 0, 0, 0, 255, 0, 0, 0,
+		0, 0, 255, 9, 9, 9, 0, 0,
+	}
+	img, err := FromBuffer(pix, 2, 2, 8, PixelFormatRGB)
+
+	assert.Nil(t, err)
+	assert.Equal(t, PixelFormatBGR, img.Format())
+	assert.Equal(t, color.NRGBA{255, 0, 0, 255}, img.At(0, 0))
+	assert.Equal(t, color.NRGBA{0, 255, 0, 255}, img.At(1, 0))
+	assert.Equal(t, color.NRGBA{0, 0, 255, 255}, img.At(0, 1))
+	assert.Equal(t, color.NRGBA{9, 9, 9, 255}, img.At(1, 1))
+}
+
+func TestFromBufferTooSmall(t *testing.T) {
+	img, err := FromBuffer(make([]byte, 10), 2, 2, 6, PixelFormatRGB)
+	assert.Nil(t, img)
+	assert.NotNil(t, err)
+}
+
+func stdlibImg(name string) image.Image {
+	b, _ := ioutil.ReadFile("./testdata/" + name)
+	m, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func BenchmarkFromImageYCbCr(b *testing.B) {
+	src := stdlibImg("lenna.jpg")
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		img, _ := FromImage(src)
+		img.Release()
+	}
+}
+
+func BenchmarkToNRGBA(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		lenna.ToNRGBA()
+	}
+}
+
+func BenchmarkToGray(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		lenna.ToGray()
+	}
+}
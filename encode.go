@@ -7,48 +7,86 @@ package prism
 import "C"
 import (
 	"errors"
-	"image/color"
-	"image/jpeg"
+	"image"
 	"io"
 	"unsafe"
 )
 
 // EncodeJPEG writes the Image img to w in JPEG 4:2:0 baseline format with the
-// given quality, from 1 - 100.
-func EncodeJPEG(w io.Writer, img *Image, quality int) (err error) {
-	defer recoverWithError(&err)
+// given quality, from 1 - 100. Images with alpha are flattened onto white;
+// use an Encoder with a Background for another color.
+func EncodeJPEG(w io.Writer, img *Image, quality int) error {
+	e := NewEncoder()
+	defer e.Close()
 
-	if img.ColorModel() == color.Gray16Model || img.ColorModel() == color.NRGBA64Model {
-		// workaround for lack of bitdepth conversion in OpenCV C API
-		err = jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
-		return
-	}
+	return e.EncodeJPEG(w, img, quality)
+}
 
-	result := C.prismEncodeJPEG(img.iplImage, C.int(quality))
-	if result == nil {
-		err = errors.New("Unable to encode JPEG image")
-		return
-	}
+// ErrTooLarge is returned when an image cannot be encoded within a size limit
+var ErrTooLarge = errors.New("Image cannot be encoded within size limit")
+
+// EncodeJPEGMaxBytes writes img to w in JPEG format at the highest quality,
+// from minQuality to 100, whose output fits within maxBytes, and returns that
+// quality. If the image is too large even at minQuality, nothing is written
+// and ErrTooLarge is returned; see EncodeJPEGMaxBytesScaled to scale it down
+// instead.
+func EncodeJPEGMaxBytes(w io.Writer, img *Image, maxBytes, minQuality int) (int, error) {
+	e := NewEncoder()
+	defer e.Close()
 
-	// write bytes directly without copying to Go-land
-	_, err = w.Write((*[1 << 30]byte)(unsafe.Pointer(result.buffer))[:result.size:result.size])
-	C.prismRelease(result)
-	return
+	return e.EncodeJPEGMaxBytes(w, img, maxBytes, minQuality)
+}
+
+// EncodeJPEGMaxBytesScaled writes img to w like EncodeJPEGMaxBytes, except that
+// if img is too large even at minQuality, a copy of it is scaled down until it
+// fits. It returns the chosen quality and the bounds of the image written.
+func EncodeJPEGMaxBytesScaled(w io.Writer, img *Image, maxBytes, minQuality int) (int, image.Rectangle, error) {
+	e := NewEncoder()
+	defer e.Close()
+
+	return e.EncodeJPEGMaxBytesScaled(w, img, maxBytes, minQuality)
+}
+
+// EncodeJPEGSSIM writes img to w in JPEG format at the lowest quality, from
+// minQuality to 100, whose luma once decoded has a structural similarity
+// (SSIM) of at least minSSIM with img's, and returns that quality and SSIM.
+// Values of minSSIM around 0.98 give results close to the source at a fraction
+// of the size of a fixed high quality. If no quality reaches minSSIM, 100 is
+// used.
+func EncodeJPEGSSIM(w io.Writer, img *Image, minSSIM float64, minQuality int) (int, float64, error) {
+	e := NewEncoder()
+	defer e.Close()
+
+	return e.EncodeJPEGSSIM(w, img, minSSIM, minQuality)
 }
 
 // EncodePNG writes the Image img to w in PNG format with the given
 // Zlib compression level, from 0 (none) - 9
 func EncodePNG(w io.Writer, img *Image, compression int) (err error) {
+	img.m.RLock()
+	defer img.m.RUnlock()
 	defer recoverWithError(&err)
 
+	if img.iplImage == nil {
+		return ErrReleased
+	}
+
 	result := C.prismEncodePNG(img.iplImage, C.int(compression))
 	if result == nil {
 		err = errors.New("Unable to encode PNG image")
 		return
 	}
 
-	// write bytes directly without copying to Go-land
-	_, err = w.Write((*[1 << 30]byte)(unsafe.Pointer(result.buffer))[:result.size:result.size])
-	C.prismRelease(result)
-	return
+	return writeEncoded(w, result)
+}
+
+// write bytes directly without copying to Go-land, then free them
+func writeEncoded(w io.Writer, result *C.PrismEncoded) error {
+	size := int64(result.size)
+	trackAlloc(size)
+	defer trackFree(size)
+	defer C.prismRelease(result)
+
+	_, err := w.Write((*[1 << 30]byte)(unsafe.Pointer(result.buffer))[:result.size:result.size])
+	return err
 }
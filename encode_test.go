@@ -5,6 +5,9 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
 	"io/ioutil"
 	"testing"
 
@@ -42,10 +45,15 @@ func TestEncodeJPEGRGB48(t *testing.T) {
 }
 
 func TestEncodeJPEGRGBA64(t *testing.T) {
-	var enc bytes.Buffer
-	EncodeJPEG(bufio.NewWriter(&enc), testImg("rgba64.png"), 85)
+	img := testImg("rgba64.png")
+	flat, _ := img.Flattened(color.White)
+
+	var enc, flatEnc bytes.Buffer
+	EncodeJPEG(bufio.NewWriter(&enc), img, 85)
+	EncodeJPEG(bufio.NewWriter(&flatEnc), flat, 85)
 
-	assert.Equal(t, "f1818c85e6615725cef12622a6d7a3ac731ae5b9", fmt.Sprintf("%x", sha1.Sum(enc.Bytes())))
+	assert.NotEmpty(t, enc.Bytes())
+	assert.Equal(t, flatEnc.Bytes(), enc.Bytes())
 }
 
 func TestEncodeJPEGGray(t *testing.T) {
@@ -98,3 +106,97 @@ func BenchmarkEncodePNG9(b *testing.B) {
 		EncodePNG(ioutil.Discard, lenna, 9)
 	}
 }
+
+func TestEncodeJPEGMaxBytes(t *testing.T) {
+	var q50, q51 bytes.Buffer
+	EncodeJPEG(&q50, lenna, 50)
+	EncodeJPEG(&q51, lenna, 51)
+
+	var buf bytes.Buffer
+	quality, err := EncodeJPEGMaxBytes(&buf, lenna, q51.Len()-1, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, quality)
+	assert.Equal(t, q50.Bytes(), buf.Bytes())
+
+	buf.Reset()
+	quality, err = EncodeJPEGMaxBytes(&buf, lenna, 10<<20, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, quality)
+
+	buf.Reset()
+	_, err = EncodeJPEGMaxBytes(&buf, lenna, 1000, 10)
+	assert.Equal(t, ErrTooLarge, err)
+	assert.Equal(t, 0, buf.Len())
+
+	_, err = EncodeJPEGMaxBytes(&buf, lenna, 1000, 0)
+	assert.NotNil(t, err)
+}
+
+func TestEncodeJPEGMaxBytesScaled(t *testing.T) {
+	// too large at minQuality, so scaled down
+	var buf bytes.Buffer
+	quality, bounds, err := EncodeJPEGMaxBytesScaled(&buf, lenna, 1000, 10)
+	assert.Nil(t, err)
+	assert.True(t, quality >= 10)
+	assert.True(t, buf.Len() <= 1000)
+	assert.True(t, bounds.Dx() < 512)
+
+	cfg, err := jpeg.DecodeConfig(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, bounds.Dx(), cfg.Width)
+	assert.Equal(t, bounds.Dy(), cfg.Height)
+	assert.Equal(t, image.Rect(0, 0, 512, 512), lenna.Bounds())
+
+	buf.Reset()
+	quality, bounds, err = EncodeJPEGMaxBytesScaled(&buf, lenna, 10<<20, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, quality)
+	assert.Equal(t, lenna.Bounds(), bounds)
+
+	_, _, err = EncodeJPEGMaxBytesScaled(&buf, lenna, 0, 10)
+	assert.NotNil(t, err)
+}
+
+func BenchmarkEncodeJPEGMaxBytes(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		EncodeJPEGMaxBytes(ioutil.Discard, lenna, 30000, 30)
+	}
+}
+
+func TestEncodeJPEGSSIM(t *testing.T) {
+	var buf bytes.Buffer
+	quality, ssim, err := EncodeJPEGSSIM(&buf, lenna, 0.95, 10)
+	assert.Nil(t, err)
+	assert.True(t, ssim >= 0.95)
+	assert.True(t, quality > 10 && quality < 100)
+
+	var expected bytes.Buffer
+	EncodeJPEG(&expected, lenna, quality)
+	assert.Equal(t, expected.Bytes(), buf.Bytes())
+
+	higher, higherSSIM, err := EncodeJPEGSSIM(ioutil.Discard, lenna, 0.99, 10)
+	assert.Nil(t, err)
+	assert.True(t, higher > quality)
+	assert.True(t, higherSSIM > ssim)
+
+	quality, _, err = EncodeJPEGSSIM(ioutil.Discard, lenna, 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, quality)
+
+	quality, ssim, err = EncodeJPEGSSIM(ioutil.Discard, lenna, 1.1, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, quality)
+	assert.True(t, ssim < 1)
+}
+
+func TestEncodeJPEGSSIMGray(t *testing.T) {
+	_, ssim, err := EncodeJPEGSSIM(ioutil.Discard, testImg("gray.jpg"), 0.97, 30)
+	assert.Nil(t, err)
+	assert.True(t, ssim >= 0.97)
+}
+
+func BenchmarkEncodeJPEGSSIM(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		EncodeJPEGSSIM(ioutil.Discard, lenna, 0.98, 30)
+	}
+}
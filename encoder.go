@@ -0,0 +1,363 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// maximum number of times EncodeJPEGMaxBytesScaled scales an image down
+const maxDownscales = 8
+
+// buffers larger than this are left to the garbage collector rather than pooled
+const maxPooledBuffer = 64 << 20
+
+// pooled buffers for reading encoded images
+var readBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// readAll reads r into a pooled buffer, to be returned with putBuffer once its
+// bytes are no longer used
+func readAll(r io.Reader) (*bytes.Buffer, error) {
+	buf := readBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	if _, err := buf.ReadFrom(r); err != nil {
+		putBuffer(buf)
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		readBuffers.Put(buf)
+	}
+}
+
+// Encoder encodes JPEG images into memory and with a libjpeg-turbo handle it
+// reuses between calls, avoiding an allocation per image. An Encoder is not
+// safe for concurrent use; use one per goroutine or worker, and Close it when
+// done.
+type Encoder struct {
+	// Background is the color images with alpha are flattened onto, as JPEG
+	// has no alpha channel; nil for white
+	Background color.Color
+
+	buf    []byte
+	handle C.tjhandle
+}
+
+// NewEncoder returns an Encoder with an empty buffer, which grows to fit the
+// largest image encoded
+func NewEncoder() *Encoder {
+	e := &Encoder{}
+	runtime.SetFinalizer(e, (*Encoder).Close)
+	return e
+}
+
+// Close returns the Encoder's handle to the shared pool and drops its buffer.
+// The Encoder takes new ones if used again.
+func (e *Encoder) Close() {
+	compressors.put(e.handle)
+	e.handle = nil
+	e.buf = nil
+}
+
+func (e *Encoder) compressor() (C.tjhandle, error) {
+	if e.handle == nil {
+		e.handle = compressors.get()
+		if e.handle == nil {
+			return nil, errors.New("Unable to initialize JPEG encoder")
+		}
+	}
+	return e.handle, nil
+}
+
+// AppendJPEG appends img encoded as JPEG with the given quality, from 1 - 100,
+// to dst and returns the extended slice. When dst has enough spare capacity
+// for the worst case size of the encoding, libjpeg-turbo writes into it
+// directly; otherwise the encoding is copied from the Encoder's buffer.
+func (e *Encoder) AppendJPEG(dst []byte, img *Image, quality int) (result []byte, err error) {
+	img, unlock, err := lockJPEGSource(img, e.Background)
+	if err != nil {
+		return dst, err
+	}
+	defer unlock()
+	defer recoverWithError(&err)
+
+	if img.iplImage.depth != C.IPL_DEPTH_16U && cap(dst)-len(dst) >= int(C.prismJPEGBufSize(img.iplImage)) {
+		handle, err := e.compressor()
+		if err != nil {
+			return dst, err
+		}
+
+		size := encodeJPEGInto(handle, img.iplImage, quality, dst[len(dst):cap(dst)])
+		if size == 0 {
+			return dst, errors.New("Unable to encode JPEG image")
+		}
+		return dst[:len(dst)+size], nil
+	}
+
+	encoded, err := e.encodeLocked(img, quality)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, encoded...), nil
+}
+
+// EncodeJPEG writes img to w like EncodeJPEG, encoding into the Encoder's
+// buffer
+func (e *Encoder) EncodeJPEG(w io.Writer, img *Image, quality int) (err error) {
+	img, unlock, err := lockJPEGSource(img, e.Background)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	defer recoverWithError(&err)
+
+	encoded, err := e.encodeLocked(img, quality)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(encoded)
+	return err
+}
+
+// EncodeJPEGMaxBytes writes img to w like EncodeJPEGMaxBytes, reusing the
+// Encoder's handle and buffer for each attempt
+func (e *Encoder) EncodeJPEGMaxBytes(w io.Writer, img *Image, maxBytes, minQuality int) (int, error) {
+	encoded, quality, err := e.encodeMaxBytes(img, maxBytes, minQuality)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = w.Write(encoded)
+	return quality, err
+}
+
+// EncodeJPEGMaxBytesScaled writes img to w like EncodeJPEGMaxBytes, except that
+// if img is too large even at minQuality, a copy of it is scaled down until it
+// fits. It returns the chosen quality and the bounds of the image written.
+func (e *Encoder) EncodeJPEGMaxBytesScaled(w io.Writer, img *Image, maxBytes, minQuality int) (int, image.Rectangle, error) {
+	current := img
+	defer func() {
+		if current != img {
+			current.Release()
+		}
+	}()
+
+	for i := 0; ; i++ {
+		encoded, quality, err := e.encodeMaxBytes(current, maxBytes, minQuality)
+		if err == nil {
+			_, err = w.Write(encoded)
+			return quality, current.Bounds(), err
+		}
+		if err != ErrTooLarge || i == maxDownscales {
+			return 0, image.Rectangle{}, err
+		}
+
+		// JPEG size is roughly proportional to area: aim a little under the
+		// area that would fit at minQuality
+		scale := math.Min(0.9*math.Sqrt(float64(maxBytes)/float64(len(encoded))), 0.9)
+		bounds := current.Bounds()
+		width := int(float64(bounds.Dx()) * scale)
+		height := int(float64(bounds.Dy()) * scale)
+		if width < 1 || height < 1 {
+			return 0, image.Rectangle{}, ErrTooLarge
+		}
+
+		// always scale from the original, to avoid compounding blur
+		scaled, err := img.Fitted(width, height)
+		if err != nil {
+			return 0, image.Rectangle{}, err
+		}
+		if current != img {
+			current.Release()
+		}
+		current = scaled
+	}
+}
+
+// encodeMaxBytes returns img encoded at the highest quality from minQuality to
+// 100 that fits within maxBytes, found by binary search. If none fits, it
+// returns ErrTooLarge along with the encoding at minQuality.
+func (e *Encoder) encodeMaxBytes(img *Image, maxBytes, minQuality int) (encoded []byte, quality int, err error) {
+	if maxBytes <= 0 {
+		return nil, 0, fmt.Errorf("Invalid size limit: %d", maxBytes)
+	}
+	if minQuality < 1 || minQuality > 100 {
+		return nil, 0, fmt.Errorf("Invalid quality: %d", minQuality)
+	}
+
+	img, unlock, err := lockJPEGSource(img, e.Background)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer unlock()
+	defer recoverWithError(&err)
+
+	if encoded, err = e.encodeLocked(img, minQuality); err != nil {
+		return nil, 0, err
+	}
+	if len(encoded) > maxBytes {
+		return encoded, 0, ErrTooLarge
+	}
+
+	best, last := minQuality, minQuality
+	lo, hi := minQuality+1, 100
+	for lo <= hi {
+		quality := (lo + hi) / 2
+		if encoded, err = e.encodeLocked(img, quality); err != nil {
+			return nil, 0, err
+		}
+		last = quality
+
+		if len(encoded) <= maxBytes {
+			best, lo = quality, quality+1
+		} else {
+			hi = quality - 1
+		}
+	}
+
+	if last != best {
+		if encoded, err = e.encodeLocked(img, best); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return encoded, best, nil
+}
+
+// EncodeJPEGSSIM writes img to w like EncodeJPEGSSIM, reusing the Encoder's
+// handle and buffer for each attempt
+func (e *Encoder) EncodeJPEGSSIM(w io.Writer, img *Image, minSSIM float64, minQuality int) (quality int, ssim float64, err error) {
+	if minQuality < 1 || minQuality > 100 {
+		return 0, 0, fmt.Errorf("Invalid quality: %d", minQuality)
+	}
+
+	img, unlock, err := lockJPEGSource(img, e.Background)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+	defer recoverWithError(&err)
+
+	handle := decompressors.get()
+	defer decompressors.put(handle)
+	if handle == nil {
+		return 0, 0, errors.New("Unable to initialize JPEG decoder")
+	}
+
+	luma := C.prismLuma(img.iplImage)
+	defer C.cvReleaseImage(&luma)
+
+	// binary search for the lowest passing quality, as SSIM grows with quality
+	var encoded []byte
+	last := 0
+	lo, hi := minQuality, 100
+	for lo <= hi {
+		q := (lo + hi) / 2
+		if encoded, err = e.encodeLocked(img, q); err != nil {
+			return 0, 0, err
+		}
+		last = q
+
+		s, err := decodedSSIM(handle, luma, encoded)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if s >= minSSIM {
+			quality, ssim, hi = q, s, q-1
+		} else {
+			lo = q + 1
+		}
+	}
+
+	if quality == 0 {
+		// even the highest quality falls short
+		quality = 100
+		if last != quality {
+			if encoded, err = e.encodeLocked(img, quality); err != nil {
+				return 0, 0, err
+			}
+		}
+		if ssim, err = decodedSSIM(handle, luma, encoded); err != nil {
+			return 0, 0, err
+		}
+	} else if last != quality {
+		if encoded, err = e.encodeLocked(img, quality); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	_, err = w.Write(encoded)
+	return quality, ssim, err
+}
+
+// SSIM of luma and the luma of an encoded JPEG image
+func decodedSSIM(handle C.tjhandle, luma *C.IplImage, encoded []byte) (float64, error) {
+	decoded := C.prismDecodeLuma(handle, unsafe.Pointer(&encoded[0]), C.ulong(len(encoded)))
+	if decoded == nil {
+		return 0, errors.New("Unable to decode JPEG image")
+	}
+	defer C.cvReleaseImage(&decoded)
+
+	return float64(C.prismSSIM(luma, decoded)), nil
+}
+
+// encodeLocked encodes img, whose lock must be held, into the Encoder's buffer
+// or, for 16-bit images, a new one
+func (e *Encoder) encodeLocked(img *Image, quality int) ([]byte, error) {
+	if img.colorModel() == color.Gray16Model || img.colorModel() == color.NRGBA64Model {
+		// workaround for lack of bitdepth conversion in OpenCV C API
+		var buf bytes.Buffer
+		err := jpeg.Encode(&buf, lockedImage{img}, &jpeg.Options{Quality: quality})
+		return buf.Bytes(), err
+	}
+
+	return e.encode(img.iplImage, quality, int(C.prismJPEGBufSize(img.iplImage)))
+}
+
+// encode into the Encoder's buffer, grown to bufSize if needed, returning the
+// encoded part of it
+func (e *Encoder) encode(iplImage *C.IplImage, quality, bufSize int) ([]byte, error) {
+	handle, err := e.compressor()
+	if err != nil {
+		return nil, err
+	}
+
+	if cap(e.buf) < bufSize {
+		e.buf = make([]byte, bufSize)
+	}
+
+	size := encodeJPEGInto(handle, iplImage, quality, e.buf[:cap(e.buf)])
+	if size == 0 {
+		return nil, errors.New("Unable to encode JPEG image")
+	}
+
+	return e.buf[:size], nil
+}
+
+// buf must hold at least prismJPEGBufSize bytes
+func encodeJPEGInto(handle C.tjhandle, iplImage *C.IplImage, quality int, buf []byte) int {
+	return int(C.prismEncodeJPEGInto(handle, iplImage, C.int(quality), (*C.uchar)(unsafe.Pointer(&buf[0]))))
+}
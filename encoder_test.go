@@ -0,0 +1,101 @@
+package prism
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncoderAppendJPEG(t *testing.T) {
+	var expected bytes.Buffer
+	EncodeJPEG(&expected, lenna, 85)
+
+	enc := NewEncoder()
+
+	// copied from the encoder's buffer
+	out, err := enc.AppendJPEG([]byte("prefix"), lenna, 85)
+	assert.Nil(t, err)
+	assert.Equal(t, "prefix", string(out[:6]))
+	assert.Equal(t, expected.Bytes(), out[6:])
+
+	// encoded directly into dst
+	dst := make([]byte, 0, 2<<20)
+	out, err = enc.AppendJPEG(dst, lenna, 85)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.Bytes(), out)
+	assert.Equal(t, &dst[:1][0], &out[0])
+}
+
+func TestEncoderEncodeJPEG(t *testing.T) {
+	enc := NewEncoder()
+
+	for _, img := range []*Image{lenna, testImg("gray.jpg"), testImg("rgb48.png")} {
+		var expected, buf bytes.Buffer
+		EncodeJPEG(&expected, img, 90)
+
+		assert.Nil(t, enc.EncodeJPEG(&buf, img, 90))
+		assert.Equal(t, expected.Bytes(), buf.Bytes())
+	}
+}
+
+func TestEncoderReleased(t *testing.T) {
+	img := testImg("lenna.jpg")
+	img.Release()
+
+	enc := NewEncoder()
+	_, err := enc.AppendJPEG(nil, img, 85)
+	assert.Equal(t, ErrReleased, err)
+	assert.Equal(t, ErrReleased, enc.EncodeJPEG(ioutil.Discard, img, 85))
+}
+
+func TestReadAll(t *testing.T) {
+	buf, err := readAll(bytes.NewBuffer(lennaJPG))
+	assert.Nil(t, err)
+	assert.Equal(t, lennaJPG, buf.Bytes())
+	putBuffer(buf)
+}
+
+func BenchmarkEncoderEncodeJPEG85(b *testing.B) {
+	enc := NewEncoder()
+	for n := 0; n < b.N; n++ {
+		enc.EncodeJPEG(ioutil.Discard, lenna, 85)
+	}
+}
+
+func BenchmarkEncoderAppendJPEG85(b *testing.B) {
+	enc := NewEncoder()
+	dst := make([]byte, 0, 2<<20)
+	for n := 0; n < b.N; n++ {
+		dst, _ = enc.AppendJPEG(dst[:0], lenna, 85)
+	}
+}
+
+func TestEncoderEncodeJPEGMaxBytesScaled(t *testing.T) {
+	enc := NewEncoder()
+	defer enc.Close()
+
+	var buf bytes.Buffer
+	quality, bounds, err := enc.EncodeJPEGMaxBytesScaled(&buf, lenna, 5000, 50)
+	assert.Nil(t, err)
+	assert.True(t, quality >= 50)
+	assert.True(t, buf.Len() <= 5000)
+	assert.True(t, bounds.Dx() < 512)
+	assert.Equal(t, bounds.Dx(), bounds.Dy())
+
+	cfg, err := jpeg.DecodeConfig(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, bounds.Dx(), cfg.Width)
+
+	// the source is left untouched
+	assert.Equal(t, image.Rect(0, 0, 512, 512), lenna.Bounds())
+
+	// no scaling needed
+	buf.Reset()
+	_, bounds, err = enc.EncodeJPEGMaxBytesScaled(&buf, lenna, 1<<20, 50)
+	assert.Nil(t, err)
+	assert.Equal(t, lenna.Bounds(), bounds)
+}
@@ -0,0 +1,95 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"io"
+	"runtime"
+)
+
+// handlePool keeps turbojpeg handles for reuse, so that each decode or encode
+// does not pay for setting one up. Handles beyond its capacity are destroyed
+// when returned.
+type handlePool struct {
+	handles chan C.tjhandle
+	init    func() C.tjhandle
+}
+
+var (
+	compressors = newHandlePool(func() C.tjhandle { return C.tjInitCompress() })
+
+	decompressors = newHandlePool(func() C.tjhandle { return C.tjInitDecompress() })
+)
+
+func newHandlePool(init func() C.tjhandle) *handlePool {
+	return &handlePool{make(chan C.tjhandle, runtime.GOMAXPROCS(0)), init}
+}
+
+// get returns a pooled handle, or a new one which may be nil if
+// initialization failed
+func (p *handlePool) get() C.tjhandle {
+	select {
+	case handle := <-p.handles:
+		return handle
+	default:
+		return p.init()
+	}
+}
+
+func (p *handlePool) put(handle C.tjhandle) {
+	if handle == nil {
+		return
+	}
+
+	select {
+	case p.handles <- handle:
+	default:
+		C.tjDestroy(handle)
+	}
+}
+
+// Decoder decodes images with a libjpeg-turbo handle it keeps between calls.
+// A Decoder is not safe for concurrent use; use one per goroutine or worker,
+// and Close it when done.
+type Decoder struct {
+	handle C.tjhandle
+}
+
+// NewDecoder returns a Decoder, which takes a handle from the shared pool when
+// first used
+func NewDecoder() *Decoder {
+	d := &Decoder{}
+	runtime.SetFinalizer(d, (*Decoder).Close)
+	return d
+}
+
+// Decode decodes an image like Decode
+func (d *Decoder) Decode(r io.Reader) (*Image, error) {
+	return d.DecodeScaled(r, 0, 0)
+}
+
+// DecodeScaled decodes an image like DecodeScaled
+func (d *Decoder) DecodeScaled(r io.Reader, minWidth, minHeight int) (*Image, error) {
+	buf, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	defer putBuffer(buf)
+
+	if d.handle == nil {
+		d.handle = decompressors.get()
+	}
+
+	return decodeWith(d.handle, buf.Bytes(), minWidth, minHeight)
+}
+
+// Close returns the Decoder's handle to the shared pool. The Decoder takes a
+// new one if used again.
+func (d *Decoder) Close() {
+	decompressors.put(d.handle)
+	d.handle = nil
+}
@@ -0,0 +1,88 @@
+package prism
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlePool(t *testing.T) {
+	pool := newHandlePool(compressors.init)
+
+	handle := pool.get()
+	assert.NotNil(t, handle)
+
+	pool.put(handle)
+	reused := pool.get()
+	assert.Equal(t, handle, reused)
+	other := pool.get()
+	assert.NotEqual(t, handle, other)
+
+	// a pool without capacity destroys the handles returned to it
+	full := &handlePool{}
+	full.put(reused)
+	full.put(other)
+}
+
+func TestDecoder(t *testing.T) {
+	d := NewDecoder()
+	defer d.Close()
+
+	expected := testImg("lenna.jpg")
+	for i := 0; i < 2; i++ {
+		img, err := d.Decode(bytes.NewBuffer(lennaJPG))
+		assert.Nil(t, err)
+		assert.Equal(t, expected.Bytes(), img.Bytes())
+		img.Release()
+	}
+
+	img, err := d.DecodeScaled(bytes.NewBuffer(lennaJPG), 100, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 128, 128), img.Bounds())
+
+	// PNG images fall back to OpenCV
+	img, err = d.Decode(bytes.NewBuffer(lennaPNG))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 512, 512), img.Bounds())
+
+	d.Close()
+	_, err = d.Decode(bytes.NewBuffer(lennaJPG))
+	assert.Nil(t, err)
+}
+
+func TestEncoderClose(t *testing.T) {
+	enc := NewEncoder()
+	assert.Nil(t, enc.EncodeJPEG(ioutil.Discard, lenna, 85))
+
+	enc.Close()
+	assert.Nil(t, enc.EncodeJPEG(ioutil.Discard, lenna, 85))
+	enc.Close()
+}
+
+func BenchmarkDecodeSmall(b *testing.B) {
+	var buf bytes.Buffer
+	img, _ := lenna.Resized(64, 64)
+	EncodeJPEG(&buf, img, 85)
+
+	for n := 0; n < b.N; n++ {
+		img, _ := Decode(bytes.NewReader(buf.Bytes()))
+		img.Release()
+	}
+}
+
+func BenchmarkDecoderSmall(b *testing.B) {
+	var buf bytes.Buffer
+	img, _ := lenna.Resized(64, 64)
+	EncodeJPEG(&buf, img, 85)
+
+	d := NewDecoder()
+	defer d.Close()
+
+	for n := 0; n < b.N; n++ {
+		img, _ := d.Decode(bytes.NewReader(buf.Bytes()))
+		img.Release()
+	}
+}
@@ -0,0 +1,116 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"sort"
+	"unsafe"
+)
+
+// Perceptual hashes
+//
+// These summarize an image's appearance in 64 bits, such that resized,
+// recompressed or slightly edited copies of an image have hashes a small
+// HammingDistance apart. As a rule of thumb, a distance up to 10 suggests a
+// near-duplicate.
+
+// AverageHash returns the aHash of img: a bit per pixel of its luma scaled down
+// to 8x8, set if the pixel is brighter than the average
+func (img *Image) AverageHash() (uint64, error) {
+	luma, err := img.lumaThumbnail(8, 8)
+	if err != nil {
+		return 0, err
+	}
+
+	sum := 0
+	for _, v := range luma {
+		sum += int(v)
+	}
+
+	var hash uint64
+	for i, v := range luma {
+		if int(v)*len(luma) > sum {
+			hash |= 1 << uint(63-i)
+		}
+	}
+	return hash, nil
+}
+
+// DifferenceHash returns the dHash of img: a bit per pixel of its luma scaled
+// down to 9x8, set if the pixel is brighter than its right neighbor
+func (img *Image) DifferenceHash() (uint64, error) {
+	luma, err := img.lumaThumbnail(9, 8)
+	if err != nil {
+		return 0, err
+	}
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if luma[y*9+x] > luma[y*9+x+1] {
+				hash |= 1 << uint(63-y*8-x)
+			}
+		}
+	}
+	return hash, nil
+}
+
+// PerceptualHash returns the DCT based pHash of img: a bit per coefficient of
+// the lowest 8x8 frequencies of its luma scaled down to 32x32, set if the
+// coefficient is above the median. The DC coefficient, which only reflects
+// overall brightness, is left out of the median and its bit, the highest, is
+// always clear.
+func (img *Image) PerceptualHash() (hash uint64, err error) {
+	img.m.RLock()
+	defer img.m.RUnlock()
+	defer recoverWithError(&err)
+
+	if img.iplImage == nil {
+		return 0, ErrReleased
+	}
+
+	var coeffs [64]C.double
+	C.prismLumaDCT(img.iplImage, &coeffs[0])
+
+	sorted := make([]float64, len(coeffs)-1)
+	for i, c := range coeffs[1:] {
+		sorted[i] = float64(c)
+	}
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	for i := 1; i < len(coeffs); i++ {
+		if float64(coeffs[i]) > median {
+			hash |= 1 << uint(63-i)
+		}
+	}
+	return hash, nil
+}
+
+// HammingDistance returns the number of bits that differ between two hashes
+func HammingDistance(a, b uint64) int {
+	count := 0
+	for x := a ^ b; x != 0; x &= x - 1 {
+		count++
+	}
+	return count
+}
+
+// luma of img scaled down to width x height, row by row
+func (img *Image) lumaThumbnail(width, height int) (luma []byte, err error) {
+	img.m.RLock()
+	defer img.m.RUnlock()
+	defer recoverWithError(&err)
+
+	if img.iplImage == nil {
+		return nil, ErrReleased
+	}
+
+	luma = make([]byte, width*height)
+	C.prismLumaThumbnail(img.iplImage, C.int(width), C.int(height), (*C.uchar)(unsafe.Pointer(&luma[0])))
+	return luma, nil
+}
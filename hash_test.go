@@ -0,0 +1,75 @@
+package prism
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type hashFunc func(img *Image) (uint64, error)
+
+var hashFuncs = map[string]hashFunc{
+	"average":    (*Image).AverageHash,
+	"difference": (*Image).DifferenceHash,
+	"perceptual": (*Image).PerceptualHash,
+}
+
+func TestHashNearDuplicates(t *testing.T) {
+	png := testImg("lenna.png")
+	jpg := testImg("lenna.jpg")
+	small, _ := png.Fitted(200, 200)
+	other := testImg("mlk.png")
+
+	for name, hash := range hashFuncs {
+		h, err := hash(png)
+		assert.Nil(t, err)
+
+		hJPG, _ := hash(jpg)
+		hSmall, _ := hash(small)
+		hOther, _ := hash(other)
+
+		assert.True(t, HammingDistance(h, hJPG) <= 4, name)
+		assert.True(t, HammingDistance(h, hSmall) <= 6, name)
+		assert.True(t, HammingDistance(h, hOther) > 12, name)
+	}
+}
+
+func TestPerceptualHashIgnoresDC(t *testing.T) {
+	for _, img := range []*Image{testImg("lenna.png"), uniformImg(32, 32, 3, color.White)} {
+		h, err := img.PerceptualHash()
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(0), h&(1<<63))
+	}
+}
+
+func TestHashReleased(t *testing.T) {
+	img := testImg("lenna.jpg")
+	img.Release()
+
+	for name, hash := range hashFuncs {
+		_, err := hash(img)
+		assert.Equal(t, ErrReleased, err, name)
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	assert.Equal(t, 0, HammingDistance(0x1234, 0x1234))
+	assert.Equal(t, 1, HammingDistance(0, 1<<63))
+	assert.Equal(t, 64, HammingDistance(0, ^uint64(0)))
+	assert.Equal(t, 4, HammingDistance(0xf0, 0xff))
+}
+
+func BenchmarkPerceptualHash(b *testing.B) {
+	img := testImg("lenna.jpg")
+	for n := 0; n < b.N; n++ {
+		img.PerceptualHash()
+	}
+}
+
+func BenchmarkDifferenceHash(b *testing.B) {
+	img := testImg("lenna.jpg")
+	for n := 0; n < b.N; n++ {
+		img.DifferenceHash()
+	}
+}
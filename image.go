@@ -8,6 +8,7 @@ import "C"
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"image"
@@ -16,9 +17,9 @@ import (
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
-	"io/ioutil"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/rwcarlsen/goexif/exif"
@@ -26,34 +27,220 @@ import (
 
 var PixelLimit = 75000000 // 75MP
 
-// Wrap IplImage
+// ErrReleased is returned when using an Image after it has been released
+var ErrReleased = errors.New("Image has been released")
 
+// PixelFormat describes the channel order and sample depth of pixel data
+type PixelFormat int
+
+const (
+	PixelFormatGray   PixelFormat = iota // 8-bit gray
+	PixelFormatGray16                    // 16-bit gray
+	PixelFormatBGR                       // 8-bit blue, green, red
+	PixelFormatBGRA                      // 8-bit blue, green, red, alpha (non-premultiplied)
+	PixelFormatBGR48                     // 16-bit blue, green, red
+	PixelFormatBGRA64                    // 16-bit blue, green, red, alpha (non-premultiplied)
+
+	// formats only accepted as input by FromBuffer, and swizzled on import
+	PixelFormatRGB  // 8-bit red, green, blue
+	PixelFormatRGBA // 8-bit red, green, blue, alpha (non-premultiplied)
+)
+
+// Channels returns the number of samples per pixel
+func (f PixelFormat) Channels() int {
+	switch f {
+	case PixelFormatGray, PixelFormatGray16:
+		return 1
+	case PixelFormatBGRA, PixelFormatBGRA64, PixelFormatRGBA:
+		return 4
+	default:
+		return 3
+	}
+}
+
+// Depth returns the number of bits per sample
+func (f PixelFormat) Depth() int {
+	switch f {
+	case PixelFormatGray16, PixelFormatBGR48, PixelFormatBGRA64:
+		return 16
+	default:
+		return 8
+	}
+}
+
+// Image wraps an OpenCV IplImage allocated on the C heap.
+//
+// An Image is safe for concurrent use: methods that only read the image, such
+// as At, Bounds, Copy and the encoders, may run in parallel with each other,
+// while transforms, Set and Release wait for exclusive access. Sub-images and
+// slices returned by Pix share pixels without sharing the lock, so writes
+// through them must be synchronized by the caller.
 type Image struct {
 	iplImage *C.IplImage
 	exif     *exif.Exif
-	m        *sync.Mutex
+	m        *sync.RWMutex
+	data     *imageData
+	origin   image.Point // bounds.Min, non-zero for sub-images
+	id       uint64      // set in leak detection mode
+}
+
+// imageData is the C allocation holding an image's pixels, shared by the image
+// and its sub-images and freed when the last of them is released
+type imageData struct {
+	iplImage *C.IplImage
+	refs     int32
+	size     int64
+}
+
+func newImageData(iplImage *C.IplImage) *imageData {
+	return &imageData{iplImage, 1, trackImageAlloc(iplImage)}
 }
 
 func newImage(iplImage *C.IplImage, meta *exif.Exif) *Image {
-	image := &Image{iplImage, meta, new(sync.Mutex)}
-	runtime.SetFinalizer(image, func(img *Image) { img.Release() })
+	return wrapImage(iplImage, newImageData(iplImage), meta, image.ZP)
+}
+
+func wrapImage(iplImage *C.IplImage, data *imageData, meta *exif.Exif, origin image.Point) *Image {
+	image := &Image{iplImage, meta, new(sync.RWMutex), data, origin, 0}
+	image.id = trackImage(image.bounds())
+	runtime.SetFinalizer(image, (*Image).finalize)
 	return image
 }
 
+// replace swaps in a newly allocated iplImage, releasing the current one. The
+// new image's bounds start at (0, 0).
+func (img *Image) replace(iplImage *C.IplImage) {
+	img.release()
+	img.iplImage = iplImage
+	img.data = newImageData(iplImage)
+	img.origin = image.ZP
+}
+
+// release frees img's header and its reference to the pixel data
+func (img *Image) release() {
+	if img.iplImage == nil {
+		return
+	}
+
+	if img.isView() {
+		C.cvReleaseImageHeader(&img.iplImage)
+	}
+	if atomic.AddInt32(&img.data.refs, -1) == 0 {
+		C.cvReleaseImage(&img.data.iplImage)
+		trackImageFree(img.data.size)
+	}
+
+	img.iplImage = nil
+	img.data = nil
+}
+
+// isView reports whether img is a sub-image borrowing another image's pixels
+func (img *Image) isView() bool {
+	return img.iplImage != img.data.iplImage
+}
+
+// detach gives a view its own copy of its pixels, so that transforms leave
+// its parent untouched. img.m must be held.
+func (img *Image) detach() {
+	if img.isView() {
+		img.replace(cloned(img.iplImage))
+	}
+}
+
+// NewImage allocates a zeroed image with the given number of channels (1, 3
+// or 4) and bits per sample (8 or 16). Channels are stored in BGR(A) order.
+func NewImage(width, height, channels, depth int) (*Image, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("Invalid image size: %d x %d", width, height)
+	}
+	if channels != 1 && channels != 3 && channels != 4 {
+		return nil, fmt.Errorf("Unsupported number of channels: %d", channels)
+	}
+	if depth != 8 && depth != 16 {
+		return nil, fmt.Errorf("Unsupported depth: %d", depth)
+	}
+
+	size := C.CvSize{width: C.int(width), height: C.int(height)}
+	iplImage := C.cvCreateImage(size, C.int(depth), C.int(channels))
+	C.cvSetZero(unsafe.Pointer(iplImage))
+
+	return newImage(iplImage, nil), nil
+}
+
 func Decode(r io.Reader) (img *Image, err error) {
-	defer recoverWithError(&err)
+	return DecodeScaled(r, 0, 0)
+}
+
+// DecodeScaled decodes an image like Decode, except that JPEG images are
+// decoded directly at a reduced size using libjpeg-turbo's DCT scaling: the
+// smallest supported scale, down to 1/8, that is at least minWidth x
+// minHeight. Other formats are decoded at full size.
+func DecodeScaled(r io.Reader, minWidth, minHeight int) (img *Image, err error) {
+	buf, err := readAll(r)
+	if err != nil {
+		return
+	}
+	defer putBuffer(buf)
+
+	return decode(buf.Bytes(), minWidth, minHeight)
+}
 
-	b, err := ioutil.ReadAll(r)
+// DecodeContext decodes an image like Decode, but gives up with ctx.Err() if
+// ctx is done before decoding starts or by the time it finishes. Decoding
+// itself is not interrupted.
+func DecodeContext(ctx context.Context, r io.Reader) (img *Image, err error) {
+	buf, err := readAll(r)
 	if err != nil {
 		return
 	}
+	defer putBuffer(buf)
+
+	return decodeContext(ctx, buf.Bytes(), 0, 0)
+}
+
+// decodeContext decodes b like decode, checking ctx before and after. The
+// decode itself runs in a single libjpeg-turbo or OpenCV call, which cannot
+// be interrupted, so it runs to completion even if ctx is done meanwhile.
+func decodeContext(ctx context.Context, b []byte, minWidth, minHeight int) (*Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	img, err := decode(b, minWidth, minHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ctx.Err(); err != nil {
+		img.Release()
+		return nil, err
+	}
+
+	return img, nil
+}
+
+func decode(b []byte, minWidth, minHeight int) (*Image, error) {
+	handle := decompressors.get()
+	defer decompressors.put(handle)
+
+	return decodeWith(handle, b, minWidth, minHeight)
+}
+
+// decode b using a turbojpeg decompressor handle
+func decodeWith(handle C.tjhandle, b []byte, minWidth, minHeight int) (img *Image, err error) {
+	defer recoverWithError(&err)
 
 	err = Validate(bytes.NewReader(b))
 	if err != nil {
 		return
 	}
 
-	iplImage := C.prismDecode(unsafe.Pointer(&b[0]), C.uint(len(b)))
+	if handle == nil {
+		err = errors.New("Unable to initialize JPEG decoder")
+		return
+	}
+
+	iplImage := C.prismDecode(handle, unsafe.Pointer(&b[0]), C.uint(len(b)), C.int(minWidth), C.int(minHeight))
 	if iplImage == nil {
 		err = errors.New("Unable to decode image")
 		return
@@ -64,11 +251,148 @@ func Decode(r io.Reader) (img *Image, err error) {
 }
 
 func (img *Image) Bytes() []byte {
-	return C.GoBytes(unsafe.Pointer(img.iplImage.imageData), img.iplImage.imageSize)
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	if img.iplImage == nil {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(img.iplImage.imageData), C.int(pixSize(img.iplImage)))
+}
+
+// Pix returns the pixel data of img without copying. Rows are Stride() bytes
+// apart and samples are laid out as described by Format(), in native byte
+// order for 16-bit formats.
+//
+// The returned slice aliases C memory owned by img: it is only valid until img
+// is released or transformed, and writes to it modify the image.
+func (img *Image) Pix() []byte {
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	if img.iplImage == nil {
+		return nil
+	}
+	return img.pix()
 }
 
+func (img *Image) pix() []byte {
+	size := pixSize(img.iplImage)
+	if size == 0 {
+		return nil
+	}
+	return (*[1 << 30]byte)(unsafe.Pointer(img.iplImage.imageData))[:size:size]
+}
+
+// Stride returns the distance in bytes between vertically adjacent pixels
+func (img *Image) Stride() int {
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	if img.iplImage == nil {
+		return 0
+	}
+	return int(img.iplImage.widthStep)
+}
+
+// Format returns the channel order and depth of the image's pixel data
+func (img *Image) Format() PixelFormat {
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	if img.iplImage == nil {
+		return PixelFormatGray
+	}
+	return pixelFormat(img.iplImage)
+}
+
+// Copy returns a deep copy of img. The copy of a released image is released
+// too, so using it returns ErrReleased.
 func (img *Image) Copy() *Image {
-	return newImage(C.cvCloneImage(img.iplImage), img.exif)
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	if img.iplImage == nil {
+		return &Image{m: new(sync.RWMutex)}
+	}
+
+	var copied *Image
+	if img.isView() {
+		copied = newImage(cloned(img.iplImage), img.exif)
+	} else {
+		copied = newImage(C.cvCloneImage(img.iplImage), img.exif)
+	}
+	copied.origin = img.origin
+
+	return copied
+}
+
+// SubImage returns a view of the portion of img visible through r, as an
+// *Image sharing img's pixels: writes to either are visible in both. As with
+// the standard library's images, the view's bounds are r.Intersect(img.Bounds())
+// and its pixels keep their coordinates in img.
+//
+// The pixels are freed once img and all views of it have been released.
+// Transforms of a view, such as FlipH, Resize or Flatten, detach it: they work
+// on a copy of its pixels, leaving img untouched, and its bounds then start at
+// (0, 0). Drawing onto a view, with Set, Composite or DrawText, writes through
+// to img.
+func (img *Image) SubImage(r image.Rectangle) image.Image {
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	if img.iplImage == nil {
+		return &Image{m: new(sync.RWMutex)}
+	}
+
+	r = r.Intersect(img.bounds())
+	ipl := img.iplImage
+
+	header := C.cvCreateImageHeader(
+		C.CvSize{width: C.int(r.Dx()), height: C.int(r.Dy())},
+		ipl.depth,
+		ipl.nChannels,
+	)
+	if !r.Empty() {
+		min := r.Min.Sub(img.origin)
+		offset := min.Y*int(ipl.widthStep) + min.X*int(ipl.nChannels*ipl.depth/8)
+		C.cvSetData(unsafe.Pointer(header), unsafe.Pointer(&img.pix()[offset]), ipl.widthStep)
+	}
+
+	atomic.AddInt32(&img.data.refs, 1)
+	return wrapImage(header, img.data, img.exif, r.Min)
+}
+
+// length of pixel data from the first sample to the last, excluding padding
+// after the final row, which a sub-image does not own
+func pixSize(iplImage *C.IplImage) int {
+	if iplImage.width == 0 || iplImage.height == 0 {
+		return 0
+	}
+	rowSize := int(iplImage.width * iplImage.nChannels * iplImage.depth / 8)
+	return int(iplImage.height-1)*int(iplImage.widthStep) + rowSize
+}
+
+func pixelFormat(iplImage *C.IplImage) PixelFormat {
+	wide := iplImage.depth == C.IPL_DEPTH_16U
+
+	switch iplImage.nChannels {
+	case 1:
+		if wide {
+			return PixelFormatGray16
+		}
+		return PixelFormatGray
+	case 4:
+		if wide {
+			return PixelFormatBGRA64
+		}
+		return PixelFormatBGRA
+	default:
+		if wide {
+			return PixelFormatBGR48
+		}
+		return PixelFormatBGR
+	}
 }
 
 func Validate(r io.Reader) (err error) {
@@ -80,8 +404,20 @@ func Validate(r io.Reader) (err error) {
 }
 
 // image.Image interface
+//
+// A released image has empty bounds and reads as transparent black.
 
 func (img *Image) ColorModel() color.Model {
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	if img.iplImage == nil {
+		return color.NRGBAModel
+	}
+	return img.colorModel()
+}
+
+func (img *Image) colorModel() color.Model {
 	switch img.iplImage.nChannels * img.iplImage.depth {
 	case 8:
 		return color.GrayModel
@@ -96,11 +432,28 @@ func (img *Image) ColorModel() color.Model {
 	}
 }
 
+// At returns the color of the pixel at (x, y). Points outside the image read
+// as transparent black in its color model, as with the standard library's
+// images.
 func (img *Image) At(x, y int) color.Color {
-	scalar := C.cvGet2D(unsafe.Pointer(img.iplImage), C.int(y), C.int(x))
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	if img.iplImage == nil {
+		return color.NRGBA{}
+	}
+	return img.at(x, y)
+}
+
+func (img *Image) at(x, y int) color.Color {
+	if !(image.Point{x, y}.In(img.bounds())) {
+		return img.colorModel().Convert(color.Transparent)
+	}
+
+	scalar := C.cvGet2D(unsafe.Pointer(img.iplImage), C.int(y-img.origin.Y), C.int(x-img.origin.X))
 
 	// Convert OpenCV's BGRA representation to RGBA, which image.Image expects
-	switch img.ColorModel() {
+	switch img.colorModel() {
 	case color.GrayModel:
 		return color.Gray{uint8(scalar.val[0])}
 	case color.Gray16Model:
@@ -135,15 +488,90 @@ func (img *Image) At(x, y int) color.Color {
 }
 
 func (img *Image) Bounds() image.Rectangle {
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	if img.iplImage == nil {
+		return image.Rectangle{}
+	}
+	return img.bounds()
+}
+
+func (img *Image) bounds() image.Rectangle {
+	return img.pixelBounds().Add(img.origin)
+}
+
+// pixelBounds returns the bounds of img's IplImage, which always start at
+// (0, 0), for passing coordinates to C
+func (img *Image) pixelBounds() image.Rectangle {
 	return image.Rect(0, 0, int(img.iplImage.width), int(img.iplImage.height))
 }
 
+// draw.Image interface
+
+// Set converts c to the image's color model and stores it at (x, y) in
+// OpenCV's BGR(A) channel order. Points outside the image, or setting pixels
+// of a released image, are ignored.
+func (img *Image) Set(x, y int, c color.Color) {
+	img.m.Lock()
+	defer img.m.Unlock()
+
+	if img.iplImage == nil || !(image.Point{x, y}.In(img.bounds())) {
+		return
+	}
+
+	var scalar C.CvScalar
+	switch img.colorModel() {
+	case color.GrayModel:
+		scalar.val[0] = C.double(color.GrayModel.Convert(c).(color.Gray).Y)
+	case color.Gray16Model:
+		scalar.val[0] = C.double(color.Gray16Model.Convert(c).(color.Gray16).Y)
+	case color.NRGBA64Model:
+		nrgba := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+		scalar.val = [4]C.double{C.double(nrgba.B), C.double(nrgba.G), C.double(nrgba.R), C.double(nrgba.A)}
+	case color.NRGBAModel:
+		fallthrough
+	default:
+		nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+		scalar.val = [4]C.double{C.double(nrgba.B), C.double(nrgba.G), C.double(nrgba.R), C.double(nrgba.A)}
+	}
+
+	C.cvSet2D(unsafe.Pointer(img.iplImage), C.int(y-img.origin.Y), C.int(x-img.origin.X), scalar)
+}
+
+// Release frees the C memory held by img. Releasing an image more than once is
+// safe; any other use of a released image returns ErrReleased.
 func (img *Image) Release() {
 	img.m.Lock()
 	defer img.m.Unlock()
 
 	if img.iplImage != nil {
-		C.cvReleaseImage(&img.iplImage)
-		img.iplImage = nil
+		atomic.AddInt64(&stats.Released, 1)
+		untrackImage(img.id)
 	}
+	img.release()
 }
+
+// finalize releases img once it is unreachable without having been released
+func (img *Image) finalize() {
+	img.m.Lock()
+	defer img.m.Unlock()
+
+	if img.iplImage != nil {
+		atomic.AddInt64(&stats.FinalizerReleased, 1)
+		reportLeak(img.id, img.bounds())
+	}
+	img.release()
+}
+
+// lockedImage adapts an Image whose lock is already held by the caller to
+// image.Image, for passing to stdlib functions without re-locking per pixel
+type lockedImage struct {
+	*Image
+}
+
+func (img lockedImage) ColorModel() color.Model { return img.colorModel() }
+
+func (img lockedImage) Bounds() image.Rectangle { return img.bounds() }
+
+func (img lockedImage) At(x, y int) color.Color { return img.at(x, y) }
@@ -2,9 +2,14 @@ package prism
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
 	"io/ioutil"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,6 +40,19 @@ func testImg(name string) *Image {
 	return img
 }
 
+// uniformImg returns a new 8-bit image with the given number of channels,
+// filled with c
+func uniformImg(width, height, channels int, c color.Color) *Image {
+	img, _ := NewImage(width, height, channels, 8)
+	return fillRect(img, img.Bounds(), c)
+}
+
+// fillRect sets the pixels of img within r to c, and returns img
+func fillRect(img *Image, r image.Rectangle, c color.Color) *Image {
+	draw.Draw(img, r, image.NewUniform(c), image.ZP, draw.Src)
+	return img
+}
+
 func TestDecodeJPEG(t *testing.T) {
 	img, _ := Decode(bytes.NewBuffer(lennaJPG))
 	assert.Equal(t, "968a15332343a2794fe7b55f65bd02635e173aad", fmt.Sprintf("%x", sha1.Sum(img.Bytes())))
@@ -59,6 +77,221 @@ func TestDecodePNGBomb(t *testing.T) {
 	assert.Equal(t, "Image is too large (possible decompression bomb): 25500 x 25500", err.Error())
 }
 
+func TestFormat(t *testing.T) {
+	assert.Equal(t, PixelFormatBGR, testImg("lenna.png").Format())
+	assert.Equal(t, PixelFormatBGRA, testImg("mlk.png").Format())
+	assert.Equal(t, PixelFormatGray, testImg("gray.jpg").Format())
+	assert.Equal(t, PixelFormatBGR48, testImg("rgb48.png").Format())
+	assert.Equal(t, PixelFormatBGRA64, testImg("rgba64.png").Format())
+}
+
+func TestPix(t *testing.T) {
+	img := testImg("mlk.png")
+	pix := img.Pix()
+
+	assert.Equal(t, 525*4, img.Stride())
+	assert.Equal(t, img.Bytes(), pix)
+
+	// B, G, R, A at (10, 20)
+	i := 20*img.Stride() + 10*4
+	assert.Equal(t, color.NRGBA{pix[i+2], pix[i+1], pix[i], pix[i+3]}, img.At(10, 20))
+}
+
+func TestNewImage(t *testing.T) {
+	img, err := NewImage(40, 30, 4, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, 40, img.Bounds().Dx())
+	assert.Equal(t, 30, img.Bounds().Dy())
+	assert.Equal(t, PixelFormatBGRA64, img.Format())
+	assert.Equal(t, color.NRGBA64{}, img.At(10, 10))
+
+	_, err = NewImage(40, 30, 2, 8)
+	assert.NotNil(t, err)
+}
+
+func TestSet(t *testing.T) {
+	img, _ := NewImage(4, 4, 3, 8)
+	img.Set(1, 2, color.NRGBA{10, 20, 30, 255})
+	img.Set(5, 5, color.White)
+
+	assert.Equal(t, color.NRGBA{10, 20, 30, 255}, img.At(1, 2))
+	assert.Equal(t, []byte{30, 20, 10}, img.Pix()[2*img.Stride()+3:2*img.Stride()+6])
+}
+
+func TestSetGray(t *testing.T) {
+	img, _ := NewImage(4, 4, 1, 8)
+	img.Set(3, 3, color.White)
+
+	assert.Equal(t, color.Gray{255}, img.At(3, 3))
+}
+
+func TestSubImage(t *testing.T) {
+	img := testImg("mlk.png")
+	sub := img.SubImage(image.Rect(500, 480, 600, 600)).(*Image)
+
+	assert.Equal(t, image.Rect(500, 480, 525, 504), sub.Bounds())
+	assert.Equal(t, img.At(500, 480), sub.At(500, 480))
+	assert.Equal(t, img.At(524, 503), sub.At(524, 503))
+	assert.Equal(t, 25*24*4+23*(img.Stride()-25*4), len(sub.Pix()))
+
+	// views of views keep the same coordinates
+	subsub := sub.SubImage(image.Rect(510, 490, 515, 495)).(*Image)
+	assert.Equal(t, image.Rect(510, 490, 515, 495), subsub.Bounds())
+	assert.Equal(t, img.At(512, 492), subsub.At(512, 492))
+	subsub.Release()
+
+	// so they can be drawn like the standard library's images
+	r := image.Rect(505, 485, 515, 495)
+	dst := image.NewNRGBA(img.Bounds())
+	draw.Draw(dst, r, img.SubImage(r), r.Min, draw.Src)
+	assert.Equal(t, img.At(510, 490), dst.At(510, 490))
+
+	// views share pixels with their parent
+	draw.Draw(sub, sub.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	assert.Equal(t, color.NRGBA{255, 255, 255, 255}, img.At(510, 490))
+	assert.NotEqual(t, color.NRGBA{255, 255, 255, 255}, img.At(499, 490))
+
+	sub.Set(499, 490, color.Black)
+	assert.NotEqual(t, color.NRGBA{0, 0, 0, 255}, img.At(499, 490))
+
+	// and keep them alive after the parent is released
+	img.Release()
+	assert.Equal(t, color.NRGBA{255, 255, 255, 255}, sub.At(510, 490))
+
+	copied := sub.Copy()
+	sub.Release()
+	assert.Equal(t, image.Rect(500, 480, 525, 504), copied.Bounds())
+	assert.Equal(t, color.NRGBA{255, 255, 255, 255}, copied.At(524, 503))
+
+	cropped, _ := copied.Cropped(image.Rect(510, 490, 520, 500))
+	assert.Equal(t, image.Rect(0, 0, 10, 10), cropped.Bounds())
+}
+
+func TestSubImageDetach(t *testing.T) {
+	img := testImg("mlk.png")
+	sub := img.SubImage(image.Rect(0, 0, 100, 100)).(*Image)
+	before := img.At(50, 50)
+
+	_ = sub.Resize(10, 10)
+	sub.Set(5, 5, color.White)
+
+	assert.Equal(t, before, img.At(50, 50))
+	assert.Equal(t, 525, img.Bounds().Dx())
+
+	// as do transforms that keep their size, or change nothing
+	parent := img.Bytes()
+	transforms := map[string]func(*Image) error{
+		"FlipH":       (*Image).FlipH,
+		"FlipV":       (*Image).FlipV,
+		"Rotate180":   (*Image).Rotate180,
+		"Reorient":    (*Image).Reorient,
+		"Resize":      func(img *Image) error { return img.Resize(100, 100) },
+		"Fit":         func(img *Image) error { return img.Fit(1000, 1000) },
+		"Flatten":     func(img *Image) error { return img.Flatten(color.White) },
+		"RemoveAlpha": (*Image).RemoveAlpha,
+	}
+	for name, transform := range transforms {
+		sub := img.SubImage(image.Rect(100, 100, 200, 200)).(*Image)
+		assert.Nil(t, transform(sub), name)
+		assert.Equal(t, image.Rect(0, 0, 100, 100), sub.Bounds(), name)
+
+		sub.Set(50, 50, color.White)
+		assert.Equal(t, parent, img.Bytes(), name)
+		sub.Release()
+	}
+}
+
+func TestAtOutsideBounds(t *testing.T) {
+	img := testImg("mlk.png")
+	sub := img.SubImage(image.Rect(100, 100, 200, 200)).(*Image)
+
+	assert.Equal(t, color.NRGBA{}, sub.At(50, 50))
+	assert.Equal(t, color.NRGBA{}, sub.At(200, 150))
+	assert.Equal(t, color.NRGBA{}, img.At(-1, 0))
+	assert.Equal(t, color.Gray{}, testImg("gray.jpg").At(0, -1))
+}
+
+func TestReleased(t *testing.T) {
+	img := testImg("lenna.jpg")
+	img.Release()
+	img.Release()
+
+	assert.Equal(t, image.Rectangle{}, img.Bounds())
+	assert.Equal(t, color.NRGBA{}, img.At(0, 0))
+	assert.Equal(t, color.NRGBAModel, img.ColorModel())
+	assert.Nil(t, img.Bytes())
+	assert.Nil(t, img.Pix())
+	copied := img.Copy()
+	assert.Equal(t, image.Rectangle{}, copied.Bounds())
+	assert.Equal(t, ErrReleased, copied.Fit(10, 10))
+	copied.Release()
+	assert.Nil(t, img.ToNRGBA())
+	assert.Equal(t, image.Rectangle{}, img.SubImage(image.Rect(0, 0, 10, 10)).Bounds())
+	img.Set(0, 0, color.White)
+
+	assert.Equal(t, ErrReleased, img.Resize(10, 10))
+	assert.Equal(t, ErrReleased, img.Fit(10, 10))
+	assert.Equal(t, ErrReleased, img.Reorient())
+	assert.Equal(t, ErrReleased, img.Rotate90())
+	assert.Equal(t, ErrReleased, img.FlipH())
+	assert.Equal(t, ErrReleased, EncodeJPEG(ioutil.Discard, img, 85))
+	assert.Equal(t, ErrReleased, EncodePNG(ioutil.Discard, img, 4))
+
+	_, err := FromImage(img)
+	assert.Equal(t, ErrReleased, err)
+}
+
+func TestConcurrentUse(t *testing.T) {
+	original := testImg("lenna.png")
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+
+		go func(size int) {
+			defer wg.Done()
+			img := original.Copy()
+			defer img.Release()
+
+			assert.Nil(t, img.Fit(size, size))
+			assert.Nil(t, EncodeJPEG(ioutil.Discard, img, 85))
+		}(64 * (i + 1))
+
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, EncodePNG(ioutil.Discard, original, 1))
+			original.At(10, 10)
+			original.Bounds()
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestConcurrentRelease(t *testing.T) {
+	img := testImg("lenna.png")
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			err := img.FlipH()
+			assert.True(t, err == nil || err == ErrReleased)
+		}()
+
+		go func() {
+			defer wg.Done()
+			err := EncodeJPEG(ioutil.Discard, img, 85)
+			assert.True(t, err == nil || err == ErrReleased)
+		}()
+	}
+
+	img.Release()
+	wg.Wait()
+}
+
 func BenchmarkDecodeJPEG(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		buffer := bytes.NewBuffer(lennaJPG)
@@ -74,3 +307,14 @@ func BenchmarkDecodePNG(b *testing.B) {
 		img.Release()
 	}
 }
+
+func TestDecodeContext(t *testing.T) {
+	img, err := DecodeContext(context.Background(), bytes.NewBuffer(lennaJPG))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 512, 512), img.Bounds())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = DecodeContext(ctx, bytes.NewBuffer(lennaJPG))
+	assert.Equal(t, context.Canceled, err)
+}
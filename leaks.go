@@ -0,0 +1,180 @@
+package prism
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"log"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Leak describes an Image that was not released explicitly
+type Leak struct {
+	Bounds image.Rectangle
+	Stack  string // where the image was created
+}
+
+func (l Leak) String() string {
+	return fmt.Sprintf("prism: %v image not released, created at:\n%s", l.Bounds.Size(), l.Stack)
+}
+
+var leaks struct {
+	sync.Mutex
+	enabled bool
+	handler func(Leak)
+	lastID  uint64
+	live    map[uint64]allocation
+}
+
+type allocation struct {
+	bounds image.Rectangle
+	pcs    []uintptr
+}
+
+// EnableLeakDetection records where each Image created from now on is
+// allocated, at some cost in speed. Images that are freed by the garbage
+// collector rather than Release are passed to handler, or logged if handler is
+// nil. The handler runs on the runtime's single finalizer goroutine, so it
+// must not block: until it returns, no other finalizer in the program runs.
+func EnableLeakDetection(handler func(Leak)) {
+	leaks.Lock()
+	defer leaks.Unlock()
+
+	leaks.enabled = true
+	leaks.handler = handler
+	if leaks.live == nil {
+		leaks.live = map[uint64]allocation{}
+	}
+}
+
+// DisableLeakDetection stops recording new images
+func DisableLeakDetection() {
+	leaks.Lock()
+	defer leaks.Unlock()
+
+	leaks.enabled = false
+	leaks.handler = nil
+}
+
+// TestingT is the part of testing.TB used by VerifyReleased
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// VerifyReleased enables leak detection and returns a function reporting an
+// error to t for each Image created in the meantime that has not been
+// released, then restoring leak detection to its earlier state. Typical use
+// is:
+//
+//	defer prism.VerifyReleased(t)()
+func VerifyReleased(t TestingT) func() {
+	leaks.Lock()
+	wasEnabled, handler := leaks.enabled, leaks.handler
+	start := leaks.lastID
+	leaks.Unlock()
+
+	if !wasEnabled {
+		EnableLeakDetection(nil)
+	}
+
+	return func() {
+		for _, leak := range liveSince(start) {
+			t.Errorf("%v", leak)
+		}
+
+		leaks.Lock()
+		leaks.enabled, leaks.handler = wasEnabled, handler
+		leaks.Unlock()
+	}
+}
+
+// trackImage returns an id recording where an image of the given bounds is
+// being created, or 0 if leak detection is disabled
+func trackImage(bounds image.Rectangle) uint64 {
+	leaks.Lock()
+	defer leaks.Unlock()
+
+	if !leaks.enabled {
+		return 0
+	}
+
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(3, pcs)]
+
+	leaks.lastID++
+	leaks.live[leaks.lastID] = allocation{bounds, pcs}
+	return leaks.lastID
+}
+
+// untrackImage forgets an image that has been released
+func untrackImage(id uint64) {
+	if id == 0 {
+		return
+	}
+
+	leaks.Lock()
+	defer leaks.Unlock()
+
+	delete(leaks.live, id)
+}
+
+// reportLeak reports an image freed by the garbage collector
+func reportLeak(id uint64, bounds image.Rectangle) {
+	if id == 0 {
+		return
+	}
+
+	leaks.Lock()
+	alloc, ok := leaks.live[id]
+	delete(leaks.live, id)
+	handler := leaks.handler
+	leaks.Unlock()
+
+	if !ok {
+		return
+	}
+
+	leak := Leak{bounds, formatStack(alloc.pcs)}
+	if handler != nil {
+		handler(leak)
+	} else {
+		log.Print(leak)
+	}
+}
+
+func liveSince(start uint64) []Leak {
+	leaks.Lock()
+	defer leaks.Unlock()
+
+	var ids []int
+	for id := range leaks.live {
+		if id > start {
+			ids = append(ids, int(id))
+		}
+	}
+	sort.Ints(ids)
+
+	result := make([]Leak, len(ids))
+	for i, id := range ids {
+		alloc := leaks.live[uint64(id)]
+		result[i] = Leak{alloc.bounds, formatStack(alloc.pcs)}
+	}
+	return result
+}
+
+func formatStack(pcs []uintptr) string {
+	var buf bytes.Buffer
+
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&buf, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,87 @@
+package prism
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestVerifyReleased(t *testing.T) {
+	defer DisableLeakDetection()
+
+	rt := &recordingT{}
+	verify := VerifyReleased(rt)
+
+	img, _ := Decode(bytes.NewBuffer(lennaJPG))
+	released, _ := Decode(bytes.NewBuffer(lennaJPG))
+	released.Release()
+	verify()
+
+	assert.Len(t, rt.errors, 1)
+	assert.Contains(t, rt.errors[0], "(512,512) image not released")
+	assert.Contains(t, rt.errors[0], "TestVerifyReleased")
+
+	rt = &recordingT{}
+	verify = VerifyReleased(rt)
+	view := img.SubImage(image.Rect(0, 0, 10, 10)).(*Image)
+	view.Release()
+	img.Release()
+	verify()
+
+	assert.Empty(t, rt.errors)
+}
+
+func TestVerifyReleasedRestoresState(t *testing.T) {
+	DisableLeakDetection()
+	VerifyReleased(&recordingT{})()
+	assert.False(t, leaks.enabled)
+
+	var reported []Leak
+	EnableLeakDetection(func(leak Leak) { reported = append(reported, leak) })
+	defer DisableLeakDetection()
+	VerifyReleased(&recordingT{})()
+	assert.True(t, leaks.enabled)
+	if assert.NotNil(t, leaks.handler) {
+		leaks.handler(Leak{})
+		assert.Len(t, reported, 1)
+	}
+}
+
+func TestLeakDetectionFinalizer(t *testing.T) {
+	found := make(chan Leak, 1)
+	EnableLeakDetection(func(leak Leak) {
+		if strings.Contains(leak.Stack, "TestLeakDetectionFinalizer") {
+			found <- leak
+		}
+	})
+	defer DisableLeakDetection()
+
+	img, _ := Decode(bytes.NewBuffer(lennaJPG))
+	_ = img.Fit(100, 100)
+	img = nil
+
+	for i := 0; i < 100; i++ {
+		runtime.GC()
+		select {
+		case leak := <-found:
+			assert.Equal(t, image.Rect(0, 0, 100, 100), leak.Bounds)
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+	t.Fatal("leak not reported")
+}
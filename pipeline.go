@@ -0,0 +1,549 @@
+package prism
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const (
+	DefaultQuality     = 85
+	DefaultCompression = 6
+)
+
+// Op names an operation of a Pipeline
+type Op string
+
+const (
+	OpReorient Op = "reorient" // apply EXIF orientation
+	OpResize   Op = "resize"   // resize to Width x Height, ignoring aspect ratio
+	OpFit      Op = "fit"      // scale down to fit within Width x Height
+	OpFill     Op = "fill"     // scale to cover Width x Height, then crop to it by Gravity
+	OpCrop     Op = "crop"     // crop Width x Height at X, Y, or positioned by Gravity
+	OpRotate   Op = "rotate"   // rotate clockwise by Angle: 90, 180 or 270
+	OpFlip     Op = "flip"     // mirror along Axis: "h" or "v"
+
+	// reported by StepError when encoding the result fails
+	OpEncode Op = "encode"
+)
+
+// Gravity positions a region within a larger one
+type Gravity string
+
+const (
+	GravityCenter    Gravity = "center"
+	GravityNorth     Gravity = "north"
+	GravityNorthEast Gravity = "northeast"
+	GravityEast      Gravity = "east"
+	GravitySouthEast Gravity = "southeast"
+	GravitySouth     Gravity = "south"
+	GravitySouthWest Gravity = "southwest"
+	GravityWest      Gravity = "west"
+	GravityNorthWest Gravity = "northwest"
+)
+
+// offsets of each gravity from the center, as -1, 0 or 1 along each axis
+var gravityOffsets = map[Gravity]image.Point{
+	GravityCenter:    {0, 0},
+	GravityNorth:     {0, -1},
+	GravityNorthEast: {1, -1},
+	GravityEast:      {1, 0},
+	GravitySouthEast: {1, 1},
+	GravitySouth:     {0, 1},
+	GravitySouthWest: {-1, 1},
+	GravityWest:      {-1, 0},
+	GravityNorthWest: {-1, -1},
+}
+
+// Position returns a rectangle of the given size placed within outer by g. An
+// empty gravity is treated as GravityCenter.
+func (g Gravity) Position(outer image.Rectangle, size image.Point) image.Rectangle {
+	offset := gravityOffsets[g]
+	origin := outer.Min.Add(outer.Size().Sub(size).Div(2))
+
+	switch offset.X {
+	case -1:
+		origin.X = outer.Min.X
+	case 1:
+		origin.X = outer.Max.X - size.X
+	}
+
+	switch offset.Y {
+	case -1:
+		origin.Y = outer.Min.Y
+	case 1:
+		origin.Y = outer.Max.Y - size.Y
+	}
+
+	return image.Rectangle{origin, origin.Add(size)}
+}
+
+// Step is a single operation of a Pipeline. Only the fields used by its Op are
+// significant.
+type Step struct {
+	Op      Op      `json:"op"`
+	Width   int     `json:"width,omitempty"`
+	Height  int     `json:"height,omitempty"`
+	X       int     `json:"x,omitempty"`
+	Y       int     `json:"y,omitempty"`
+	Gravity Gravity `json:"gravity,omitempty"`
+	Angle   int     `json:"angle,omitempty"`
+	Axis    string  `json:"axis,omitempty"`
+}
+
+// Pipeline is a declarative list of transformations and the encoding of their
+// result, describing a rendition of an image.
+//
+// Pipelines can be parsed from a compact spec string (see ParsePipeline) or
+// from JSON (see ParsePipelineJSON), e.g.
+//
+//	{"steps": [{"op": "fit", "width": 800, "height": 600}], "format": "jpeg", "quality": 85}
+type Pipeline struct {
+	Steps       []Step `json:"steps"`
+	Format      string `json:"format,omitempty"`      // "jpeg" (default) or "png"
+	Quality     int    `json:"quality,omitempty"`     // JPEG quality, 1 - 100, or 0 for DefaultQuality
+	Compression int    `json:"compression,omitempty"` // PNG compression level, 0 - 9
+}
+
+// StepError reports the step of a Pipeline that is invalid or failed
+type StepError struct {
+	Index int
+	Op    Op
+	Err   error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("Step %d (%s): %v", e.Index, e.Op, e.Err)
+}
+
+// ParsePipeline parses a comma-separated pipeline spec such as
+//
+//	reorient,fit=800x600,crop=center,q=85,fmt=jpeg
+//
+// Steps are applied in order:
+//
+//	reorient          apply EXIF orientation
+//	resize=WxH        resize, ignoring aspect ratio
+//	fit=WxH           scale down to fit; either dimension may be omitted
+//	fill=WxH          scale to cover WxH and crop the center
+//	crop=WxH+X+Y      crop a region at X, Y
+//	crop=WxH+GRAVITY  crop a region positioned by GRAVITY, e.g. north
+//	crop=WxH          crop a region from the center
+//	crop=GRAVITY      turn the preceding fit into a fill cropped by GRAVITY,
+//	                  e.g. center, north or southwest
+//	rotate=DEGREES    rotate clockwise by 90, 180 or 270 degrees
+//	flip=h|v          mirror horizontally or vertically
+//
+// followed by any output options:
+//
+//	fmt=jpeg|png      output format
+//	q=N               JPEG quality, 1 - 100
+//	compression=N     PNG compression level, 0 - 9
+func ParsePipeline(spec string) (*Pipeline, error) {
+	p := &Pipeline{Quality: DefaultQuality, Compression: DefaultCompression}
+
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		key, value := field, ""
+		if i := strings.Index(field, "="); i >= 0 {
+			key, value = field[:i], field[i+1:]
+		}
+
+		if err := p.parseField(key, value); err != nil {
+			return nil, fmt.Errorf("Invalid pipeline field %q: %v", field, err)
+		}
+	}
+
+	return p, p.Validate()
+}
+
+// ParsePipelineJSON parses and validates a JSON encoded Pipeline. Output
+// options missing from the JSON take their default values.
+func ParsePipelineJSON(b []byte) (*Pipeline, error) {
+	p := &Pipeline{Quality: DefaultQuality, Compression: DefaultCompression}
+
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+
+	return p, p.Validate()
+}
+
+func (p *Pipeline) parseField(key, value string) (err error) {
+	step := Step{Op: Op(key)}
+
+	switch step.Op {
+	case OpReorient:
+	case OpResize, OpFit, OpFill:
+		step.Width, step.Height, err = parseSize(value, step.Op == OpFit)
+	case OpCrop:
+		if _, ok := gravityOffsets[Gravity(value)]; ok {
+			// fit=WxH,crop=GRAVITY describes a fill
+			if len(p.Steps) == 0 || p.Steps[len(p.Steps)-1].Op != OpFit {
+				return fmt.Errorf("crop=%s must follow fit", value)
+			}
+			last := &p.Steps[len(p.Steps)-1]
+			last.Op = OpFill
+			last.Gravity = Gravity(value)
+			return nil
+		}
+		err = parseCrop(value, &step)
+	case OpRotate:
+		step.Angle, err = strconv.Atoi(value)
+	case OpFlip:
+		step.Axis = value
+	case "fmt", "format":
+		p.Format = value
+		return nil
+	case "q", "quality":
+		// unlike in JSON, where 0 leaves it unset, a quality given must be valid
+		if p.Quality, err = strconv.Atoi(value); err == nil && (p.Quality < 1 || p.Quality > 100) {
+			err = fmt.Errorf("expected a quality from 1 - 100")
+		}
+		return err
+	case "compression":
+		p.Compression, err = strconv.Atoi(value)
+		return err
+	default:
+		return fmt.Errorf("unknown operation")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	p.Steps = append(p.Steps, step)
+	return nil
+}
+
+// parse WxH, allowing either side to be omitted if optional is set
+func parseSize(value string, optional bool) (width, height int, err error) {
+	parts := strings.Split(value, "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected WIDTHxHEIGHT")
+	}
+
+	if parts[0] != "" || !optional {
+		if width, err = strconv.Atoi(parts[0]); err != nil {
+			return
+		}
+	}
+
+	if parts[1] != "" || !optional {
+		height, err = strconv.Atoi(parts[1])
+	}
+
+	return
+}
+
+// parse WxH+X+Y, WxH+GRAVITY or WxH
+func parseCrop(value string, step *Step) (err error) {
+	parts := strings.Split(value, "+")
+	if len(parts) > 3 {
+		return fmt.Errorf("expected WIDTHxHEIGHT+X+Y")
+	}
+
+	if step.Width, step.Height, err = parseSize(parts[0], false); err != nil {
+		return
+	}
+
+	switch len(parts) {
+	case 1:
+		step.Gravity = GravityCenter
+		return nil
+	case 2:
+		step.Gravity = Gravity(parts[1])
+		return nil
+	}
+
+	if step.X, err = strconv.Atoi(parts[1]); err != nil {
+		return
+	}
+	step.Y, err = strconv.Atoi(parts[2])
+	return
+}
+
+// Validate checks the parameters of every step and the output options
+func (p *Pipeline) Validate() error {
+	for i, step := range p.Steps {
+		if err := step.validate(); err != nil {
+			return &StepError{i, step.Op, err}
+		}
+	}
+
+	switch p.Format {
+	case "", "jpeg", "jpg", "png":
+	default:
+		return fmt.Errorf("Unsupported format: %s", p.Format)
+	}
+
+	if p.Quality < 0 || p.Quality > 100 {
+		return fmt.Errorf("Invalid JPEG quality: %d", p.Quality)
+	}
+
+	if p.Compression < 0 || p.Compression > 9 {
+		return fmt.Errorf("Invalid PNG compression level: %d", p.Compression)
+	}
+
+	return nil
+}
+
+func (s Step) validate() error {
+	if s.Gravity != "" {
+		if _, ok := gravityOffsets[s.Gravity]; !ok {
+			return fmt.Errorf("unknown gravity %q", s.Gravity)
+		}
+	}
+
+	switch s.Op {
+	case OpReorient:
+	case OpResize, OpFill:
+		if s.Width <= 0 || s.Height <= 0 {
+			return fmt.Errorf("invalid size %dx%d", s.Width, s.Height)
+		}
+	case OpFit:
+		if s.Width < 0 || s.Height < 0 || s.Width == 0 && s.Height == 0 {
+			return fmt.Errorf("invalid size %dx%d", s.Width, s.Height)
+		}
+	case OpCrop:
+		if s.Width <= 0 || s.Height <= 0 || s.X < 0 || s.Y < 0 {
+			return fmt.Errorf("invalid region %dx%d+%d+%d", s.Width, s.Height, s.X, s.Y)
+		}
+	case OpRotate:
+		if s.Angle != 90 && s.Angle != 180 && s.Angle != 270 {
+			return fmt.Errorf("unsupported angle %d", s.Angle)
+		}
+	case OpFlip:
+		if s.Axis != "h" && s.Axis != "v" {
+			return fmt.Errorf("unknown axis %q", s.Axis)
+		}
+	default:
+		return fmt.Errorf("unknown operation")
+	}
+
+	return nil
+}
+
+// Apply runs the pipeline's steps on img, which is left untouched, and returns
+// the result. Steps are reordered and combined for efficiency (see Plan), and a
+// step that cannot be applied is reported as a *StepError. The caller is
+// responsible for releasing the returned Image.
+func (p *Pipeline) Apply(img *Image) (*Image, error) {
+	return p.ApplyContext(context.Background(), img)
+}
+
+// ApplyContext is like Apply, but gives up with ctx.Err() between stages once
+// ctx is done
+func (p *Pipeline) ApplyContext(ctx context.Context, img *Image) (*Image, error) {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, ErrReleased
+	}
+
+	plan, err := p.Plan(bounds.Dx(), bounds.Dy(), img.Orientation())
+	if err != nil {
+		return nil, err
+	}
+
+	return plan.ApplyContext(ctx, img)
+}
+
+// Execute applies the pipeline to img and writes the result to w in the
+// pipeline's output format
+func (p *Pipeline) Execute(w io.Writer, img *Image) error {
+	return p.ExecuteContext(context.Background(), w, img)
+}
+
+// ExecuteContext is like Execute, but gives up with ctx.Err() between stages
+// once ctx is done
+func (p *Pipeline) ExecuteContext(ctx context.Context, w io.Writer, img *Image) error {
+	out, err := p.ApplyContext(ctx, img)
+	if err != nil {
+		return err
+	}
+	defer out.Release()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if err = p.Encode(w, out); err != nil {
+		return &StepError{len(p.Steps), OpEncode, err}
+	}
+
+	return nil
+}
+
+// Process decodes an image from r, applies the pipeline and writes the result
+// to w. JPEG images are decoded at the smallest scale that does not reduce the
+// resolution of the result (see DecodeScaled).
+func (p *Pipeline) Process(w io.Writer, r io.Reader) error {
+	return p.ProcessContext(context.Background(), w, r)
+}
+
+// ProcessContext is like Process, but gives up with ctx.Err() between decoding,
+// each transform stage and encoding once ctx is done
+func (p *Pipeline) ProcessContext(ctx context.Context, w io.Writer, r io.Reader) error {
+	buf, err := readAll(r)
+	if err != nil {
+		return err
+	}
+	defer putBuffer(buf)
+	b := buf.Bytes()
+
+	cfg, _, orientation, err := decodeHeader(b)
+	if err != nil {
+		return err
+	}
+
+	plan, err := p.Plan(cfg.Width, cfg.Height, orientation)
+	if err != nil {
+		return err
+	}
+
+	return p.process(ctx, w, b, plan, nil)
+}
+
+// process decodes b and executes plan, encoding JPEG output with enc if not nil
+func (p *Pipeline) process(ctx context.Context, w io.Writer, b []byte, plan *Plan, enc *Encoder) error {
+	decodeW, decodeH := plan.DecodeSize()
+	img, err := decodeContext(ctx, b, decodeW, decodeH)
+	if err != nil {
+		return err
+	}
+	defer img.Release()
+
+	out, err := plan.ApplyContext(ctx, img)
+	if err != nil {
+		return err
+	}
+	defer out.Release()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if err = p.encode(w, out, enc); err != nil {
+		return &StepError{len(p.Steps), OpEncode, err}
+	}
+
+	return nil
+}
+
+// image size and format, and EXIF orientation, read without decoding pixels
+func decodeHeader(b []byte) (cfg image.Config, format string, orientation int, err error) {
+	cfg, format, err = image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+
+	orientation = 1
+	if meta, err := exif.Decode(bytes.NewReader(b)); err == nil {
+		if o, err := orientationOf(meta); err == nil {
+			orientation = o
+		}
+	}
+
+	return cfg, format, orientation, nil
+}
+
+// Encode writes img to w using the pipeline's output options, without applying
+// its steps
+func (p *Pipeline) Encode(w io.Writer, img *Image) error {
+	switch p.Format {
+	case "png":
+		return EncodePNG(w, img, p.Compression)
+	default:
+		return EncodeJPEG(w, img, p.quality())
+	}
+}
+
+// JPEG quality, or the default if unset
+func (p *Pipeline) quality() int {
+	if p.Quality == 0 {
+		return DefaultQuality
+	}
+	return p.Quality
+}
+
+func (p *Pipeline) encode(w io.Writer, img *Image, enc *Encoder) error {
+	if enc == nil || p.Format == "png" {
+		return p.Encode(w, img)
+	}
+	return enc.EncodeJPEG(w, img, p.quality())
+}
+
+// size of the smallest image with the aspect ratio of srcW x srcH covering
+// width x height
+func coverSize(srcW, srcH, width, height int) (int, int) {
+	srcAspectRatio := float64(srcW) / float64(srcH)
+	if srcAspectRatio > float64(width)/float64(height) {
+		return int(float64(height)*srcAspectRatio + 0.5), height
+	}
+	return width, int(float64(width)/srcAspectRatio + 0.5)
+}
+
+// String formats the pipeline as a spec accepted by ParsePipeline
+func (p *Pipeline) String() string {
+	var fields []string
+	for _, s := range p.Steps {
+		fields = append(fields, s.String())
+	}
+
+	if p.Format != "" {
+		fields = append(fields, "fmt="+p.Format)
+	}
+	if p.Format == "png" {
+		fields = append(fields, fmt.Sprintf("compression=%d", p.Compression))
+	} else if p.Quality != 0 {
+		fields = append(fields, fmt.Sprintf("q=%d", p.Quality))
+	}
+
+	return strings.Join(fields, ",")
+}
+
+func (s Step) String() string {
+	switch s.Op {
+	case OpFit:
+		return fmt.Sprintf("fit=%sx%s", optionalInt(s.Width), optionalInt(s.Height))
+	case OpResize:
+		return fmt.Sprintf("resize=%dx%d", s.Width, s.Height)
+	case OpFill:
+		if s.Gravity == "" || s.Gravity == GravityCenter {
+			return fmt.Sprintf("fill=%dx%d", s.Width, s.Height)
+		}
+		return fmt.Sprintf("fit=%dx%d,crop=%s", s.Width, s.Height, s.Gravity)
+	case OpCrop:
+		switch s.Gravity {
+		case "":
+			return fmt.Sprintf("crop=%dx%d+%d+%d", s.Width, s.Height, s.X, s.Y)
+		case GravityCenter:
+			return fmt.Sprintf("crop=%dx%d", s.Width, s.Height)
+		default:
+			return fmt.Sprintf("crop=%dx%d+%s", s.Width, s.Height, s.Gravity)
+		}
+	case OpRotate:
+		return fmt.Sprintf("rotate=%d", s.Angle)
+	case OpFlip:
+		return "flip=" + s.Axis
+	}
+	return string(s.Op)
+}
+
+func optionalInt(i int) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.Itoa(i)
+}
@@ -0,0 +1,169 @@
+package prism
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePipeline(t *testing.T) {
+	p, err := ParsePipeline("reorient,fit=800x600,crop=center,q=90,fmt=jpeg")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Step{
+		{Op: OpReorient},
+		{Op: OpFill, Width: 800, Height: 600, Gravity: GravityCenter},
+	}, p.Steps)
+	assert.Equal(t, "jpeg", p.Format)
+	assert.Equal(t, 90, p.Quality)
+}
+
+func TestParsePipelineSteps(t *testing.T) {
+	p, err := ParsePipeline("fit=x200,crop=50x60+10+20,crop=30x30+north,rotate=270,flip=v,resize=10x20,fmt=png,compression=9")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Step{
+		{Op: OpFit, Height: 200},
+		{Op: OpCrop, Width: 50, Height: 60, X: 10, Y: 20},
+		{Op: OpCrop, Width: 30, Height: 30, Gravity: GravityNorth},
+		{Op: OpRotate, Angle: 270},
+		{Op: OpFlip, Axis: "v"},
+		{Op: OpResize, Width: 10, Height: 20},
+	}, p.Steps)
+	assert.Equal(t, "png", p.Format)
+	assert.Equal(t, 9, p.Compression)
+}
+
+func TestParsePipelineInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"blur=3",
+		"fit=800",
+		"crop=center",
+		"fit=x600,crop=center",
+		"rotate=45",
+		"flip=x",
+		"resize=0x10",
+		"crop=10x10+nowhere",
+		"q=101",
+		"q=0",
+		"quality=-1",
+		"fmt=gif",
+	} {
+		_, err := ParsePipeline(spec)
+		assert.NotNil(t, err, spec)
+	}
+
+	_, err := ParsePipeline("fit=10x10,rotate=45")
+	assert.Equal(t, &StepError{1, OpRotate, fmt.Errorf("unsupported angle 45")}, err)
+}
+
+func TestParsePipelineJSON(t *testing.T) {
+	p, err := ParsePipelineJSON([]byte(`{"steps": [{"op": "fit", "width": 800, "height": 600}, {"op": "rotate", "angle": 90}], "format": "png"}`))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Step{{Op: OpFit, Width: 800, Height: 600}, {Op: OpRotate, Angle: 90}}, p.Steps)
+	assert.Equal(t, DefaultCompression, p.Compression)
+
+	_, err = ParsePipelineJSON([]byte(`{"steps": [{"op": "fit"}]}`))
+	assert.NotNil(t, err)
+}
+
+func TestPipelineString(t *testing.T) {
+	spec := "reorient,fit=800x,fit=800x600,crop=north,fill=10x10,crop=10x20+1+2,crop=5x5,crop=5x5+east,rotate=90,flip=h,fmt=jpeg,q=70"
+	p, err := ParsePipeline(spec)
+	assert.Nil(t, err)
+
+	reparsed, err := ParsePipeline(p.String())
+	assert.Nil(t, err)
+	assert.Equal(t, p, reparsed)
+}
+
+func TestGravityPosition(t *testing.T) {
+	outer := image.Rect(0, 0, 100, 50)
+	size := image.Pt(20, 10)
+
+	assert.Equal(t, image.Rect(40, 20, 60, 30), GravityCenter.Position(outer, size))
+	assert.Equal(t, image.Rect(40, 20, 60, 30), Gravity("").Position(outer, size))
+	assert.Equal(t, image.Rect(0, 0, 20, 10), GravityNorthWest.Position(outer, size))
+	assert.Equal(t, image.Rect(80, 40, 100, 50), GravitySouthEast.Position(outer, size))
+	assert.Equal(t, image.Rect(80, 20, 100, 30), GravityEast.Position(outer, size))
+}
+
+func TestPipelineApply(t *testing.T) {
+	img := testImg("mlk.png")
+	p, _ := ParsePipeline("fit=100x100")
+
+	out, err := p.Apply(img)
+	assert.Nil(t, err)
+	assert.Equal(t, "663fc1b903e0e4090f926687cc55c6829f57fa37", fmt.Sprintf("%x", sha1.Sum(out.Bytes())))
+	assert.Equal(t, 525, img.Bounds().Dx())
+}
+
+func TestPipelineApplyFill(t *testing.T) {
+	img := testImg("mlk.png")
+	p, _ := ParsePipeline("fit=100x50,crop=south,rotate=90")
+
+	out, err := p.Apply(img)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 50, 100), out.Bounds())
+}
+
+func TestPipelineApplyStepError(t *testing.T) {
+	img := testImg("mlk.png")
+	p, _ := ParsePipeline("fit=100x100,crop=10x10+500+500")
+
+	out, err := p.Apply(img)
+	assert.Nil(t, out)
+	assert.Equal(t, 1, err.(*StepError).Index)
+	assert.Equal(t, OpCrop, err.(*StepError).Op)
+}
+
+func TestPipelineExecute(t *testing.T) {
+	img := testImg("lenna.png")
+	p, _ := ParsePipeline("fit=64x64,fmt=png")
+
+	var buf bytes.Buffer
+	assert.Nil(t, p.Execute(&buf, img))
+
+	cfg, format, err := image.DecodeConfig(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, 64, cfg.Width)
+	assert.Equal(t, 64, cfg.Height)
+}
+
+func BenchmarkPipelineExecute(b *testing.B) {
+	p, _ := ParsePipeline("reorient,fit=256x256,crop=center,q=85")
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		p.Execute(ioutil.Discard, lenna)
+	}
+}
+
+func TestPipelineContext(t *testing.T) {
+	p, _ := ParsePipeline("fit=100x100,rotate=90")
+	img := testImg("lenna.jpg")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := p.ApplyContext(ctx, img)
+	assert.Equal(t, context.Canceled, err)
+
+	var buf bytes.Buffer
+	assert.Equal(t, context.Canceled, p.ExecuteContext(ctx, &buf, img))
+	assert.Equal(t, context.Canceled, p.ProcessContext(ctx, &buf, bytes.NewBuffer(lennaJPG)))
+	assert.Equal(t, 0, buf.Len())
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	assert.Nil(t, p.ProcessContext(ctx, &buf, bytes.NewBuffer(lennaJPG)))
+}
@@ -0,0 +1,166 @@
+package prism
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"math"
+)
+
+const (
+	// size that BlurHash scales images down to before encoding; more detail
+	// than this is lost in the blur anyway
+	blurHashSize = 32
+
+	// JPEG quality of placeholders
+	placeholderQuality = 30
+)
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash returns the BlurHash (https://blurha.sh) of img, a short string that
+// clients decode into a blurred placeholder, with xComponents x yComponents
+// cosine components from 1 to 9 each. 4 x 3 suits most landscape images.
+// Images with alpha are flattened onto white first, as with Placeholder.
+func BlurHash(img *Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", errors.New("BlurHash components must be from 1 to 9")
+	}
+
+	small, err := img.Fitted(blurHashSize, blurHashSize)
+	if err != nil {
+		return "", err
+	}
+	defer small.Release()
+	if err = small.Flatten(color.White); err != nil {
+		return "", err
+	}
+
+	nrgba := small.ToNRGBA()
+	if nrgba == nil {
+		return "", ErrReleased
+	}
+	width, height := nrgba.Rect.Dx(), nrgba.Rect.Dy()
+
+	// convert to linear RGB once
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*nrgba.Stride + x*4
+			linear[y*width+x] = [3]float64{
+				sRGBToLinear(nrgba.Pix[i]),
+				sRGBToLinear(nrgba.Pix[i+1]),
+				sRGBToLinear(nrgba.Pix[i+2]),
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalization := 2.0
+			if i == 0 && j == 0 {
+				normalization = 1
+			}
+
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalization *
+						math.Cos(math.Pi*float64(i*x)/float64(width)) *
+						math.Cos(math.Pi*float64(j*y)/float64(height))
+					for c, v := range linear[y*width+x] {
+						factor[c] += basis * v
+					}
+				}
+			}
+
+			scale := 1 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash bytes.Buffer
+	encode83(&hash, (xComponents-1)+(yComponents-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maximum := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, f := range ac {
+			for _, v := range f {
+				actualMaximum = math.Max(actualMaximum, math.Abs(v))
+			}
+		}
+		quantized := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximum = float64(quantized+1) / 166
+		encode83(&hash, quantized, 1)
+	} else {
+		encode83(&hash, 0, 1)
+	}
+
+	encode83(&hash, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+
+	for _, f := range ac {
+		quantized := 0
+		for _, v := range f {
+			q := int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+			quantized = quantized*19 + q
+		}
+		encode83(&hash, quantized, 2)
+	}
+
+	return hash.String(), nil
+}
+
+// Placeholder returns a low quality JPEG of img scaled down to fit within
+// maxDim x maxDim, small enough to inline in a page as base64 while the full
+// image loads. A maxDim of 16 to 32 pixels, stretched and blurred by the
+// client, typically produces a few hundred bytes.
+func Placeholder(img *Image, maxDim int) ([]byte, error) {
+	if maxDim < 1 {
+		return nil, errors.New("Placeholder size must be positive")
+	}
+
+	small, err := img.Fitted(maxDim, maxDim)
+	if err != nil {
+		return nil, err
+	}
+	defer small.Release()
+
+	e := NewEncoder()
+	defer e.Close()
+
+	return e.AppendJPEG(nil, small, placeholderQuality)
+}
+
+func encode83(buf *bytes.Buffer, value, length int) {
+	divisor := 1
+	for i := 1; i < length; i++ {
+		divisor *= 83
+	}
+
+	for ; divisor > 0; divisor /= 83 {
+		buf.WriteByte(base83[value/divisor%83])
+	}
+}
+
+func sRGBToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
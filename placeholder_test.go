@@ -0,0 +1,54 @@
+package prism
+
+import (
+	"bytes"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlurHash(t *testing.T) {
+	img := uniformImg(16, 16, 3, red)
+
+	hash, err := BlurHash(img, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "00TI:j", hash)
+
+	hash, err = BlurHash(testImg("lenna.jpg"), 4, 3)
+	assert.Nil(t, err)
+	assert.Len(t, hash, 28)
+	assert.Equal(t, byte('L'), hash[0])
+
+	// transparent pixels count as white, not as the black beneath them
+	transparent, _ := BlurHash(uniformImg(16, 16, 4, color.Transparent), 4, 3)
+	white, _ := BlurHash(uniformImg(16, 16, 3, color.White), 4, 3)
+	assert.Equal(t, white, transparent)
+
+	_, err = BlurHash(img, 0, 3)
+	assert.NotNil(t, err)
+	_, err = BlurHash(img, 4, 10)
+	assert.NotNil(t, err)
+}
+
+func TestPlaceholder(t *testing.T) {
+	b, err := Placeholder(testImg("mlk.png"), 24)
+	assert.Nil(t, err)
+	assert.True(t, len(b) < 2000)
+
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.True(t, cfg.Width <= 24 && cfg.Height <= 24)
+	assert.True(t, cfg.Width == 24 || cfg.Height == 24)
+
+	_, err = Placeholder(testImg("mlk.png"), 0)
+	assert.NotNil(t, err)
+}
+
+func BenchmarkBlurHash(b *testing.B) {
+	img := testImg("lenna.jpg")
+	for n := 0; n < b.N; n++ {
+		BlurHash(img, 4, 3)
+	}
+}
@@ -0,0 +1,355 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"unsafe"
+)
+
+// orientation is one of the 8 ways to rotate and flip a rectangle: an optional
+// transpose followed by optional horizontal and vertical flips
+type orientation struct {
+	transpose, flipX, flipY bool
+}
+
+var (
+	orientFlipH     = orientation{false, true, false}
+	orientFlipV     = orientation{false, false, true}
+	orientRotate90  = orientation{true, true, false}
+	orientRotate180 = orientation{false, true, true}
+	orientRotate270 = orientation{true, false, true}
+)
+
+// orientations that undo each EXIF orientation value
+var exifOrientations = map[int]orientation{
+	1: {},
+	2: orientFlipH,
+	3: orientRotate180,
+	4: orientFlipV,
+	5: {true, false, false},
+	6: orientRotate90,
+	7: {true, true, true},
+	8: orientRotate270,
+}
+
+// then returns the orientation equivalent to applying o followed by next
+func (o orientation) then(next orientation) orientation {
+	if next.transpose {
+		// flips before a transpose act on the other axis after it
+		o.flipX, o.flipY = o.flipY, o.flipX
+	}
+
+	return orientation{
+		o.transpose != next.transpose,
+		o.flipX != next.flipX,
+		o.flipY != next.flipY,
+	}
+}
+
+// cvFlip flip code, and whether any flip is needed
+func (o orientation) flipCode() (int, bool) {
+	switch {
+	case o.flipX && o.flipY:
+		return -1, true
+	case o.flipX:
+		return 1, true
+	case o.flipY:
+		return 0, true
+	}
+	return 0, false
+}
+
+// region is a rectangle of the source image, in fractional pixels
+type region struct {
+	x0, y0, x1, y1 float64
+}
+
+// Plan is an optimized execution of a Pipeline's steps for a source image of a
+// particular size and orientation.
+//
+// Any sequence of resizes, crops, rotations and flips is equivalent to cropping
+// a region of the source, resizing it, then applying a single rotation and/or
+// flip. A Plan executes steps in that order, so that pixels that are cropped
+// away are never resized, and rotations and flips run on the smallest image.
+type Plan struct {
+	srcW, srcH    int
+	region        region
+	width, height int // size after resizing, before orientation
+	orient        orientation
+}
+
+// Plan computes an optimized execution of the pipeline's steps for a width x
+// height source image with the given EXIF orientation, from 1 to 8. Steps
+// that cannot be applied, such as crops outside the image, are reported as a
+// *StepError.
+func (p *Pipeline) Plan(width, height, exifOrientation int) (*Plan, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("Invalid image size: %d x %d", width, height)
+	}
+
+	plan := &Plan{
+		srcW:   width,
+		srcH:   height,
+		region: region{0, 0, float64(width), float64(height)},
+		width:  width,
+		height: height,
+	}
+
+	for i, step := range p.Steps {
+		if err := plan.add(step, exifOrientation); err != nil {
+			return nil, &StepError{i, step.Op, err}
+		}
+	}
+
+	return plan, nil
+}
+
+func (plan *Plan) add(step Step, exifOrientation int) error {
+	switch step.Op {
+	case OpReorient:
+		plan.orient = plan.orient.then(exifOrientations[exifOrientation])
+	case OpResize:
+		plan.resize(step.Width, step.Height)
+	case OpFit:
+		if w, h, ok := fitSize(plan.bounds(), step.Width, step.Height); ok {
+			plan.resize(w, h)
+		}
+	case OpFill:
+		w, h := coverSize(plan.bounds().Dx(), plan.bounds().Dy(), step.Width, step.Height)
+		plan.resize(w, h)
+		return plan.crop(step.Gravity.Position(image.Rect(0, 0, w, h), image.Pt(step.Width, step.Height)))
+	case OpCrop:
+		r := image.Rect(step.X, step.Y, step.X+step.Width, step.Y+step.Height)
+		if step.Gravity != "" {
+			r = step.Gravity.Position(plan.bounds(), image.Pt(step.Width, step.Height))
+		}
+		return plan.crop(r)
+	case OpRotate:
+		switch step.Angle {
+		case 90:
+			plan.orient = plan.orient.then(orientRotate90)
+		case 180:
+			plan.orient = plan.orient.then(orientRotate180)
+		default:
+			plan.orient = plan.orient.then(orientRotate270)
+		}
+	case OpFlip:
+		if step.Axis == "v" {
+			plan.orient = plan.orient.then(orientFlipV)
+		} else {
+			plan.orient = plan.orient.then(orientFlipH)
+		}
+	}
+
+	return nil
+}
+
+// bounds of the image as seen by the next step, after orientation
+func (plan *Plan) bounds() image.Rectangle {
+	if plan.orient.transpose {
+		return image.Rect(0, 0, plan.height, plan.width)
+	}
+	return image.Rect(0, 0, plan.width, plan.height)
+}
+
+func (plan *Plan) resize(width, height int) {
+	if plan.orient.transpose {
+		width, height = height, width
+	}
+	plan.width, plan.height = width, height
+}
+
+func (plan *Plan) crop(r image.Rectangle) error {
+	bounds := plan.bounds()
+	r, err := cropRect(bounds, r)
+	if err != nil {
+		return err
+	}
+
+	// undo the orientation, to crop before it is applied
+	if plan.orient.flipX {
+		r.Min.X, r.Max.X = bounds.Max.X-r.Max.X, bounds.Max.X-r.Min.X
+	}
+	if plan.orient.flipY {
+		r.Min.Y, r.Max.Y = bounds.Max.Y-r.Max.Y, bounds.Max.Y-r.Min.Y
+	}
+	if plan.orient.transpose {
+		r = image.Rect(r.Min.Y, r.Min.X, r.Max.Y, r.Max.X)
+	}
+
+	// then undo the resize, to crop the source region
+	scaleX := (plan.region.x1 - plan.region.x0) / float64(plan.width)
+	scaleY := (plan.region.y1 - plan.region.y0) / float64(plan.height)
+	plan.region = region{
+		plan.region.x0 + float64(r.Min.X)*scaleX,
+		plan.region.y0 + float64(r.Min.Y)*scaleY,
+		plan.region.x0 + float64(r.Max.X)*scaleX,
+		plan.region.y0 + float64(r.Max.Y)*scaleY,
+	}
+	plan.width, plan.height = r.Dx(), r.Dy()
+
+	return nil
+}
+
+// Bounds returns the bounds of the image the plan produces
+func (plan *Plan) Bounds() image.Rectangle {
+	return plan.bounds()
+}
+
+// DecodeSize returns the smallest size the source can be decoded at, e.g. with
+// DecodeScaled, without reducing the resolution of the result
+func (plan *Plan) DecodeSize() (width, height int) {
+	scaleX := float64(plan.width) / (plan.region.x1 - plan.region.x0)
+	scaleY := float64(plan.height) / (plan.region.y1 - plan.region.y0)
+
+	width = int(math.Ceil(float64(plan.srcW) * math.Min(scaleX, 1)))
+	height = int(math.Ceil(float64(plan.srcH) * math.Min(scaleY, 1)))
+	return
+}
+
+// Apply executes the plan on img, which is left untouched, and returns the
+// result. img must be the source the plan was computed for, or a scaled down
+// version of it such as one decoded with DecodeScaled.
+func (plan *Plan) Apply(img *Image) (*Image, error) {
+	return plan.ApplyContext(context.Background(), img)
+}
+
+// ApplyContext is like Apply, but gives up with ctx.Err() between stages once
+// ctx is done
+func (plan *Plan) ApplyContext(ctx context.Context, img *Image) (*Image, error) {
+	return img.derive(func(src *C.IplImage) (*C.IplImage, error) {
+		return plan.execute(ctx, src)
+	})
+}
+
+func (plan *Plan) execute(ctx context.Context, src *C.IplImage) (*C.IplImage, error) {
+	size := C.cvGetSize(unsafe.Pointer(src))
+	bounds := image.Rect(0, 0, int(size.width), int(size.height))
+
+	r, err := plan.sourceRect(bounds)
+	if err != nil {
+		return nil, err
+	}
+
+	current := src
+	next := func(iplImage *C.IplImage) {
+		if current != src {
+			C.cvReleaseImage(&current)
+		}
+		current = iplImage
+	}
+
+	// checked between stages, releasing the intermediate result once ctx is done
+	done := func() error {
+		err := ctx.Err()
+		if err != nil {
+			next(nil)
+		}
+		return err
+	}
+
+	if err := done(); err != nil {
+		return nil, err
+	}
+
+	// resize straight from the region of the source, without copying it first
+	if r.Dx() != plan.width || r.Dy() != plan.height {
+		view := C.prismView(current, C.int(r.Min.X), C.int(r.Min.Y), C.int(r.Dx()), C.int(r.Dy()))
+		result := resized(view, plan.width, plan.height)
+		C.cvReleaseImageHeader(&view)
+
+		next(result)
+		if err := done(); err != nil {
+			return nil, err
+		}
+	} else if r != bounds {
+		next(cropped(current, r))
+		if err := done(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the source itself must not be flipped in place
+	oriented, err := plan.reorient(current, current != src)
+	if oriented != current {
+		next(oriented)
+	}
+	if err != nil {
+		next(nil)
+		return nil, err
+	}
+
+	if current == src {
+		return cloned(src), nil
+	}
+
+	return current, nil
+}
+
+// rectangle of the source region within bounds, which may be smaller than the
+// source the plan was computed for if it was decoded at a reduced scale
+func (plan *Plan) sourceRect(bounds image.Rectangle) (image.Rectangle, error) {
+	scaleX := float64(bounds.Dx()) / float64(plan.srcW)
+	scaleY := float64(bounds.Dy()) / float64(plan.srcH)
+	r := image.Rect(
+		round(plan.region.x0*scaleX),
+		round(plan.region.y0*scaleY),
+		round(plan.region.x1*scaleX),
+		round(plan.region.y1*scaleY),
+	).Add(bounds.Min).Intersect(bounds)
+
+	if r.Empty() {
+		return r, errors.New("Image is too small for plan")
+	}
+
+	return r, nil
+}
+
+// oriented returns a copy of iplImage, already cropped and resized, with the
+// plan's orientation applied
+func (plan *Plan) oriented(iplImage *C.IplImage) (*C.IplImage, error) {
+	result, err := plan.reorient(iplImage, false)
+	if result == iplImage {
+		return cloned(iplImage), err
+	}
+	return result, err
+}
+
+// reorient applies the plan's orientation to iplImage, returning either a new
+// image or, when there is nothing to transpose, iplImage itself, flipped in
+// place if inPlace
+func (plan *Plan) reorient(iplImage *C.IplImage, inPlace bool) (*C.IplImage, error) {
+	code, flips := plan.orient.flipCode()
+
+	result := iplImage
+	if plan.orient.transpose {
+		size := C.cvGetSize(unsafe.Pointer(iplImage))
+		result = allocTarget(iplImage, int(size.height), int(size.width))
+		C.cvTranspose(unsafe.Pointer(iplImage), unsafe.Pointer(result))
+	}
+
+	if !flips {
+		return result, nil
+	}
+	if result == iplImage && !inPlace {
+		return flipped(iplImage, code), nil
+	}
+	return result, flip(result, code)
+}
+
+func round(f float64) int {
+	return int(math.Floor(f + 0.5))
+}
@@ -0,0 +1,134 @@
+package prism
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrientationThen(t *testing.T) {
+	assert.Equal(t, orientRotate180, orientRotate90.then(orientRotate90))
+	assert.Equal(t, orientRotate270, orientRotate180.then(orientRotate90))
+	assert.Equal(t, orientation{}, orientRotate270.then(orientRotate90))
+	assert.Equal(t, orientation{}, orientFlipH.then(orientFlipH))
+	assert.Equal(t, orientRotate180, orientFlipH.then(orientFlipV))
+
+	// as applied by Reorient
+	assert.Equal(t, exifOrientations[5], orientRotate90.then(orientFlipH))
+	assert.Equal(t, exifOrientations[7], orientRotate270.then(orientFlipH))
+}
+
+func TestPlanCropAfterRotate(t *testing.T) {
+	p, _ := ParsePipeline("rotate=90,crop=10x20+0+0")
+	plan, err := p.Plan(100, 50, 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, region{0, 40, 20, 50}, plan.region)
+	assert.Equal(t, image.Rect(0, 0, 10, 20), plan.Bounds())
+}
+
+func TestPlanCropAfterResize(t *testing.T) {
+	p, _ := ParsePipeline("resize=50x25,crop=10x10+20+5")
+	plan, err := p.Plan(100, 50, 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, region{40, 10, 60, 30}, plan.region)
+	assert.Equal(t, 10, plan.width)
+	assert.Equal(t, 10, plan.height)
+}
+
+func TestPlanResizeAfterRotate(t *testing.T) {
+	p, _ := ParsePipeline("reorient,fit=100x100")
+	plan, err := p.Plan(1000, 500, 6)
+
+	assert.Nil(t, err)
+	assert.Equal(t, orientRotate90, plan.orient)
+	assert.Equal(t, 100, plan.width)
+	assert.Equal(t, 50, plan.height)
+	assert.Equal(t, image.Rect(0, 0, 50, 100), plan.Bounds())
+}
+
+func TestPlanDecodeSize(t *testing.T) {
+	p, _ := ParsePipeline("fit=100x100")
+	plan, _ := p.Plan(1000, 800, 1)
+	w, h := plan.DecodeSize()
+	assert.Equal(t, 100, w)
+	assert.Equal(t, 80, h)
+
+	p, _ = ParsePipeline("crop=500x400+0+0,fit=100x100")
+	plan, _ = p.Plan(1000, 800, 1)
+	w, h = plan.DecodeSize()
+	assert.Equal(t, 200, w)
+	assert.Equal(t, 160, h)
+
+	p, _ = ParsePipeline("resize=2000x1600")
+	plan, _ = p.Plan(1000, 800, 1)
+	w, h = plan.DecodeSize()
+	assert.Equal(t, 1000, w)
+	assert.Equal(t, 800, h)
+}
+
+func TestPlanMatchesSteps(t *testing.T) {
+	img := testImg("mlk.png")
+	p, _ := ParsePipeline("rotate=90,flip=h,crop=300x200+10+20,rotate=180,flip=v")
+
+	out, err := p.Apply(img)
+	assert.Nil(t, err)
+
+	_ = img.Rotate90()
+	_ = img.FlipH()
+	_ = img.Crop(image.Rect(10, 20, 310, 220))
+	_ = img.Rotate180()
+	_ = img.FlipV()
+
+	assert.Equal(t, img.Bounds(), out.Bounds())
+	assert.Equal(t, img.Bytes(), out.Bytes())
+}
+
+func TestPlanReorient(t *testing.T) {
+	for i := 1; i <= 8; i++ {
+		img := testImg(fmt.Sprintf("orientations/orientation-%d.jpg", i))
+		p, _ := ParsePipeline("reorient")
+
+		out, err := p.Apply(img)
+		assert.Nil(t, err)
+
+		_ = img.Reorient()
+		assert.Equal(t, fmt.Sprintf("%x", sha1.Sum(img.Bytes())), fmt.Sprintf("%x", sha1.Sum(out.Bytes())))
+	}
+}
+
+func TestDecodeScaled(t *testing.T) {
+	img, err := DecodeScaled(bytes.NewBuffer(lennaJPG), 100, 100)
+
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 128, 128), img.Bounds())
+
+	img, _ = DecodeScaled(bytes.NewBuffer(lennaPNG), 100, 100)
+	assert.Equal(t, image.Rect(0, 0, 512, 512), img.Bounds())
+}
+
+func TestPipelineProcess(t *testing.T) {
+	p, _ := ParsePipeline("reorient,fit=100x100,fmt=png")
+
+	var buf bytes.Buffer
+	assert.Nil(t, p.Process(&buf, bytes.NewBuffer(lennaJPG)))
+
+	cfg, _, err := image.DecodeConfig(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, cfg.Width)
+	assert.Equal(t, 100, cfg.Height)
+}
+
+func BenchmarkPipelineProcess(b *testing.B) {
+	p, _ := ParsePipeline("reorient,fit=128x128,q=85")
+
+	for n := 0; n < b.N; n++ {
+		p.Process(ioutil.Discard, bytes.NewBuffer(lennaJPG))
+	}
+}
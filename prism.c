@@ -13,17 +13,42 @@ void printError(char* errorStr) {
   fflush(stderr);
 }
 
-IplImage* prismDecode(void* data, unsigned int dataSize) {
+// pick the smallest DCT scaling factor that decodes width x height to at least
+// minWidth x minHeight, without upscaling
+void prismScaledSize(int* width, int* height, int minWidth, int minHeight) {
+  int i, n, scaledW, scaledH;
+  int bestW = *width, bestH = *height;
+  tjscalingfactor* factors = tjGetScalingFactors(&n);
+
+  if (minWidth <= 0 || minHeight <= 0 || !factors) {
+    return;
+  }
+
+  for (i = 0; i < n; i++) {
+    if (factors[i].num > factors[i].denom) {
+      continue;
+    }
+
+    scaledW = TJSCALED(*width, factors[i]);
+    scaledH = TJSCALED(*height, factors[i]);
+    if (scaledW >= minWidth && scaledH >= minHeight && scaledW * scaledH < bestW * bestH) {
+      bestW = scaledW;
+      bestH = scaledH;
+    }
+  }
+
+  *width = bestW;
+  *height = bestH;
+}
+
+IplImage* prismDecode(tjhandle jpeg, void* data, unsigned int dataSize, int minWidth, int minHeight) {
   int err;
   IplImage* iplImage;
-  tjhandle jpeg = tjInitDecompress();
   int width, height, subsamp, colorspace;
 
   // attempt to decode JPEG header
   err = tjDecompressHeader3(jpeg, (unsigned char*)data, dataSize, &width, &height, &subsamp, &colorspace);
   if (err) {
-    tjDestroy(jpeg);
-
     // fall back to OpenCV decoding
     CvMat* cvMat = cvCreateMatHeader(1, dataSize, CV_8UC1);
     cvSetData(cvMat, data, dataSize);
@@ -43,11 +68,12 @@ IplImage* prismDecode(void* data, unsigned int dataSize) {
     pixelFmt = TJPF_GRAY;
   }
 
+  prismScaledSize(&width, &height, minWidth, minHeight);
+
   unsigned char* buffer = cvAlloc(width * height * channels);
   err = tjDecompress2(
-          jpeg, (unsigned char*)data, dataSize, buffer, 0, 0, 0, pixelFmt, TJFLAG_FASTDCT
+          jpeg, (unsigned char*)data, dataSize, buffer, width, 0, height, pixelFmt, TJFLAG_FASTDCT
         );
-  tjDestroy(jpeg);
 
   if (err) {
     char* errorStr = tjGetErrorStr();
@@ -63,41 +89,50 @@ IplImage* prismDecode(void* data, unsigned int dataSize) {
   return iplImage;
 }
 
-PrismEncoded* prismEncodeJPEG(IplImage* img, int quality) {
-  int pixFmt, subsamp;
-
+// turbojpeg pixel format and chroma subsampling for encoding img
+static void jpegFormat(IplImage* img, int* pixFmt, int* subsamp) {
   switch (img->nChannels) {
   case 1:
-    pixFmt = TJPF_GRAY;
-    subsamp = TJSAMP_GRAY;
+    *pixFmt = TJPF_GRAY;
+    *subsamp = TJSAMP_GRAY;
     break;
   case 4:
-    pixFmt = TJPF_BGRA;
-    subsamp = TJSAMP_420;
+    *pixFmt = TJPF_BGRA;
+    *subsamp = TJSAMP_420;
     break;
   default:
-    pixFmt = TJPF_BGR;
-    subsamp = TJSAMP_420;
+    *pixFmt = TJPF_BGR;
+    *subsamp = TJSAMP_420;
   }
+}
+
+unsigned long prismJPEGBufSize(IplImage* img) {
+  int pixFmt, subsamp;
+  jpegFormat(img, &pixFmt, &subsamp);
+
+  return tjBufSize(img->width, img->height, subsamp);
+}
+
+// encode into buffer, of at least prismJPEGBufSize bytes, returning the size
+// of the JPEG image or 0 on failure
+unsigned long prismEncodeJPEGInto(tjhandle jpeg, IplImage* img, int quality, unsigned char* buffer) {
+  int pixFmt, subsamp;
+  jpegFormat(img, &pixFmt, &subsamp);
 
   int err;
-  CvSize size = cvGetSize(img);
-  tjhandle jpeg = tjInitCompress();
-  PrismEncoded* enc = calloc(1, sizeof(PrismEncoded));
+  unsigned long size = 0;
 
   err = tjCompress2(
-          jpeg, (unsigned char*)img->imageData, size.width, img->widthStep,
-          size.height, pixFmt, &enc->buffer, &enc->size, subsamp, quality, 0
+          jpeg, (unsigned char*)img->imageData, img->width, img->widthStep,
+          img->height, pixFmt, &buffer, &size, subsamp, quality, TJFLAG_NOREALLOC
         );
-  tjDestroy(jpeg);
 
   if (err) {
     printError(tjGetErrorStr());
-    prismRelease(enc);
-    return NULL;
+    return 0;
   }
 
-  return enc;
+  return size;
 }
 
 PrismEncoded* prismEncodePNG(IplImage* img, int compression) {
@@ -128,3 +163,719 @@ void prismRelease(PrismEncoded* enc) {
   }
   free(enc);
 }
+
+// header for the width x height region of img at x, y, sharing its pixels, to
+// be freed with cvReleaseImageHeader
+IplImage* prismView(IplImage* img, int x, int y, int width, int height) {
+  IplImage* view = cvCreateImageHeader(cvSize(width, height), img->depth, img->nChannels);
+  cvSetData(view, img->imageData + y * img->widthStep + x * img->nChannels * (img->depth / 8), img->widthStep);
+  return view;
+}
+
+IplImage* prismCrop(IplImage* img, int x, int y, int width, int height) {
+  IplImage* view = prismView(img, x, y, width, height);
+
+  IplImage* dst = cvCreateImage(cvSize(width, height), img->depth, img->nChannels);
+  cvCopy(view, dst, NULL);
+  cvReleaseImageHeader(&view);
+
+  return dst;
+}
+
+// read sample i of row as 8 bits, truncating 16-bit images
+static inline unsigned char sample8(IplImage* img, char* row, int i) {
+  if (img->depth == IPL_DEPTH_16U) {
+    return ((unsigned short*)row)[i] >> 8;
+  }
+  return ((unsigned char*)row)[i];
+}
+
+void prismToNRGBA(IplImage* img, unsigned char* dst, int dstStride) {
+  int x, y, channels = img->nChannels;
+
+  for (y = 0; y < img->height; y++) {
+    char* row = img->imageData + y * img->widthStep;
+    unsigned char* out = dst + y * dstStride;
+
+    for (x = 0; x < img->width; x++, out += 4) {
+      int i = x * channels;
+      switch (channels) {
+      case 1:
+        out[0] = out[1] = out[2] = sample8(img, row, i);
+        out[3] = 255;
+        break;
+      case 4:
+        out[0] = sample8(img, row, i + 2);
+        out[1] = sample8(img, row, i + 1);
+        out[2] = sample8(img, row, i);
+        out[3] = sample8(img, row, i + 3);
+        break;
+      default:
+        out[0] = sample8(img, row, i + 2);
+        out[1] = sample8(img, row, i + 1);
+        out[2] = sample8(img, row, i);
+        out[3] = 255;
+      }
+    }
+  }
+}
+
+void prismToGray(IplImage* img, unsigned char* dst, int dstStride) {
+  int x, y, channels = img->nChannels;
+
+  for (y = 0; y < img->height; y++) {
+    char* row = img->imageData + y * img->widthStep;
+    unsigned char* out = dst + y * dstStride;
+
+    for (x = 0; x < img->width; x++) {
+      int i = x * channels;
+      if (channels == 1) {
+        out[x] = sample8(img, row, i);
+        continue;
+      }
+
+      // same weights and rounding as color.GrayModel, on 16-bit values
+      unsigned int b = sample8(img, row, i) * 0x101;
+      unsigned int g = sample8(img, row, i + 1) * 0x101;
+      unsigned int r = sample8(img, row, i + 2) * 0x101;
+      out[x] = (19595 * r + 38470 * g + 7471 * b + (1 << 15)) >> 24;
+    }
+  }
+}
+
+IplImage* prismFromBuffer(unsigned char* src, int width, int height, int srcStride, int channels, int depth, int swapRB) {
+  int x, y, rowSize = width * channels * (depth / 8);
+  IplImage* img = cvCreateImage(cvSize(width, height), depth, channels);
+
+  for (y = 0; y < height; y++) {
+    unsigned char* in = src + y * srcStride;
+    char* out = img->imageData + y * img->widthStep;
+    memcpy(out, in, rowSize);
+
+    if (!swapRB) {
+      continue;
+    }
+
+    // RGB(A) to BGR(A)
+    for (x = 0; x < width * channels; x += channels) {
+      if (depth == IPL_DEPTH_16U) {
+        unsigned short* px = (unsigned short*)out + x;
+        unsigned short r = px[0];
+        px[0] = px[2];
+        px[2] = r;
+      } else {
+        char r = out[x];
+        out[x] = out[x + 2];
+        out[x + 2] = r;
+      }
+    }
+  }
+
+  return img;
+}
+
+IplImage* prismFromRGBA(unsigned char* src, int width, int height, int srcStride) {
+  int x, y;
+  IplImage* img = cvCreateImage(cvSize(width, height), IPL_DEPTH_8U, 4);
+
+  for (y = 0; y < height; y++) {
+    unsigned char* in = src + y * srcStride;
+    unsigned char* out = (unsigned char*)img->imageData + y * img->widthStep;
+
+    for (x = 0; x < width; x++, in += 4, out += 4) {
+      // unpremultiply as color.NRGBAModel does
+      unsigned int a = in[3];
+      if (a == 0 || a == 255) {
+        out[0] = a ? in[2] : 0;
+        out[1] = a ? in[1] : 0;
+        out[2] = a ? in[0] : 0;
+      } else {
+        out[0] = (in[2] * 0x101u * 0xffff / (a * 0x101)) >> 8;
+        out[1] = (in[1] * 0x101u * 0xffff / (a * 0x101)) >> 8;
+        out[2] = (in[0] * 0x101u * 0xffff / (a * 0x101)) >> 8;
+      }
+      out[3] = a;
+    }
+  }
+
+  return img;
+}
+
+// clamp a 16.16 fixed point value to 8 bits, as color.YCbCrToRGB does
+static inline unsigned char clampFixed(int v) {
+  if (((unsigned int)v & 0xff000000) == 0) {
+    return v >> 16;
+  }
+  return ~(v >> 31);
+}
+
+IplImage* prismFromYCbCr(unsigned char* y, int yStride, unsigned char* cb, unsigned char* cr, int cStride,
+                         int width, int height, int minX, int minY, int hShift, int vShift) {
+  int i, j;
+  IplImage* img = cvCreateImage(cvSize(width, height), IPL_DEPTH_8U, 3);
+
+  for (j = 0; j < height; j++) {
+    unsigned char* yRow = y + j * yStride;
+    int cRow = (((minY + j) >> vShift) - (minY >> vShift)) * cStride;
+    unsigned char* out = (unsigned char*)img->imageData + j * img->widthStep;
+
+    for (i = 0; i < width; i++, out += 3) {
+      int ci = cRow + ((minX + i) >> hShift) - (minX >> hShift);
+      int yy = yRow[i] * 0x10101;
+      int cb1 = cb[ci] - 128;
+      int cr1 = cr[ci] - 128;
+
+      out[0] = clampFixed(yy + 116130 * cb1);
+      out[1] = clampFixed(yy - 22554 * cb1 - 46802 * cr1);
+      out[2] = clampFixed(yy + 91881 * cr1);
+    }
+  }
+
+  return img;
+}
+
+// 8-bit luma of img, as computed by JPEG encoding
+IplImage* prismLuma(IplImage* img) {
+  CvSize size = cvGetSize(img);
+  IplImage* src = img;
+  IplImage* luma = cvCreateImage(size, IPL_DEPTH_8U, 1);
+
+  if (img->depth == IPL_DEPTH_16U) {
+    src = cvCreateImage(size, IPL_DEPTH_8U, img->nChannels);
+    cvConvertScale(img, src, 1.0 / 256, 0);
+  }
+
+  switch (img->nChannels) {
+  case 1:
+    cvCopy(src, luma, NULL);
+    break;
+  case 4:
+    cvCvtColor(src, luma, CV_BGRA2GRAY);
+    break;
+  default:
+    cvCvtColor(src, luma, CV_BGR2GRAY);
+  }
+
+  if (src != img) {
+    cvReleaseImage(&src);
+  }
+
+  return luma;
+}
+
+// decode the luma of a JPEG image at full size
+IplImage* prismDecodeLuma(tjhandle jpeg, void* data, unsigned long dataSize) {
+  int width, height, subsamp, colorspace;
+
+  if (tjDecompressHeader3(jpeg, (unsigned char*)data, dataSize, &width, &height, &subsamp, &colorspace)) {
+    printError(tjGetErrorStr());
+    return NULL;
+  }
+
+  IplImage* luma = cvCreateImage(cvSize(width, height), IPL_DEPTH_8U, 1);
+  if (tjDecompress2(jpeg, (unsigned char*)data, dataSize, (unsigned char*)luma->imageData,
+                    width, luma->widthStep, height, TJPF_GRAY, TJFLAG_FASTDCT)) {
+    printError(tjGetErrorStr());
+    cvReleaseImage(&luma);
+    return NULL;
+  }
+
+  return luma;
+}
+
+// mean structural similarity (Wang et al. 2004) of two single channel images
+// of the same size, over 11x11 gaussian windows
+double prismSSIM(IplImage* a, IplImage* b) {
+  const double c1 = 6.5025, c2 = 58.5225; // (0.01 * 255)^2, (0.03 * 255)^2
+  CvSize size = cvGetSize(a);
+  IplImage* tmp[12];
+  int i;
+
+  for (i = 0; i < 12; i++) {
+    tmp[i] = cvCreateImage(size, IPL_DEPTH_32F, 1);
+  }
+
+  IplImage *x = tmp[0], *y = tmp[1], *xx = tmp[2], *yy = tmp[3], *xy = tmp[4];
+  IplImage *muX = tmp[5], *muY = tmp[6], *sigmaXX = tmp[7], *sigmaYY = tmp[8], *sigmaXY = tmp[9];
+  IplImage *num = tmp[10], *den = tmp[11];
+
+  cvConvert(a, x);
+  cvConvert(b, y);
+  cvMul(x, x, xx, 1);
+  cvMul(y, y, yy, 1);
+  cvMul(x, y, xy, 1);
+
+  cvSmooth(x, muX, CV_GAUSSIAN, 11, 11, 1.5, 0);
+  cvSmooth(y, muY, CV_GAUSSIAN, 11, 11, 1.5, 0);
+  cvSmooth(xx, sigmaXX, CV_GAUSSIAN, 11, 11, 1.5, 0);
+  cvSmooth(yy, sigmaYY, CV_GAUSSIAN, 11, 11, 1.5, 0);
+  cvSmooth(xy, sigmaXY, CV_GAUSSIAN, 11, 11, 1.5, 0);
+
+  // reuse x, y and xy for the products of means
+  cvMul(muX, muX, x, 1);
+  cvMul(muY, muY, y, 1);
+  cvMul(muX, muY, xy, 1);
+  cvSub(sigmaXX, x, sigmaXX, NULL);
+  cvSub(sigmaYY, y, sigmaYY, NULL);
+  cvSub(sigmaXY, xy, sigmaXY, NULL);
+
+  // (2 muX muY + c1) (2 sigmaXY + c2)
+  cvConvertScale(xy, num, 2, c1);
+  cvConvertScale(sigmaXY, xx, 2, c2);
+  cvMul(num, xx, num, 1);
+
+  // (muX^2 + muY^2 + c1) (sigmaXX + sigmaYY + c2)
+  cvAdd(x, y, den, NULL);
+  cvAddS(den, cvScalarAll(c1), den, NULL);
+  cvAdd(sigmaXX, sigmaYY, yy, NULL);
+  cvAddS(yy, cvScalarAll(c2), yy, NULL);
+  cvMul(den, yy, den, 1);
+
+  cvDiv(num, den, num, 1);
+  double ssim = cvAvg(num, NULL).val[0];
+
+  for (i = 0; i < 12; i++) {
+    cvReleaseImage(&tmp[i]);
+  }
+
+  return ssim;
+}
+
+// 8-bit copy of img with the given number of channels, which must not exceed
+// img's; 3 channel images lose alpha, and 1 channel images keep only luma
+static IplImage* toChannels(IplImage* img, int channels) {
+  CvSize size = cvGetSize(img);
+  IplImage* src = img;
+  IplImage* dst = cvCreateImage(size, IPL_DEPTH_8U, channels);
+
+  if (img->depth == IPL_DEPTH_16U) {
+    src = cvCreateImage(size, IPL_DEPTH_8U, img->nChannels);
+    cvConvertScale(img, src, 1.0 / 256, 0);
+  }
+
+  if (src->nChannels == channels) {
+    cvCopy(src, dst, NULL);
+  } else if (channels == 1) {
+    cvCvtColor(src, dst, src->nChannels == 4 ? CV_BGRA2GRAY : CV_BGR2GRAY);
+  } else {
+    cvCvtColor(src, dst, CV_BGRA2BGR);
+  }
+
+  if (src != img) {
+    cvReleaseImage(&src);
+  }
+
+  return dst;
+}
+
+// compare two images of the same size channel by channel, in BGR(A) order,
+// after converting both to the fewer channels of the two. Returns the number of
+// channels compared, and their mean squared errors and SSIMs.
+int prismCompare(IplImage* a, IplImage* b, double* mse, double* ssim) {
+  int i, channels = a->nChannels < b->nChannels ? a->nChannels : b->nChannels;
+  CvSize size = cvGetSize(a);
+  IplImage* planesA[4] = {NULL, NULL, NULL, NULL};
+  IplImage* planesB[4] = {NULL, NULL, NULL, NULL};
+
+  IplImage* convA = toChannels(a, channels);
+  IplImage* convB = toChannels(b, channels);
+
+  for (i = 0; i < channels; i++) {
+    planesA[i] = cvCreateImage(size, IPL_DEPTH_8U, 1);
+    planesB[i] = cvCreateImage(size, IPL_DEPTH_8U, 1);
+  }
+
+  if (channels == 1) {
+    cvCopy(convA, planesA[0], NULL);
+    cvCopy(convB, planesB[0], NULL);
+  } else {
+    cvSplit(convA, planesA[0], planesA[1], planesA[2], planesA[3]);
+    cvSplit(convB, planesB[0], planesB[1], planesB[2], planesB[3]);
+  }
+
+  for (i = 0; i < channels; i++) {
+    double norm = cvNorm(planesA[i], planesB[i], CV_L2, NULL);
+    mse[i] = norm * norm / ((double)size.width * size.height);
+    ssim[i] = prismSSIM(planesA[i], planesB[i]);
+
+    cvReleaseImage(&planesA[i]);
+    cvReleaseImage(&planesB[i]);
+  }
+
+  cvReleaseImage(&convA);
+  cvReleaseImage(&convB);
+
+  return channels;
+}
+
+// luma of img scaled down to width x height, written to dst without padding
+void prismLumaThumbnail(IplImage* img, int width, int height, unsigned char* dst) {
+  int y;
+  IplImage* small = cvCreateImage(cvSize(width, height), img->depth, img->nChannels);
+  cvResize(img, small, CV_INTER_AREA);
+
+  IplImage* luma = prismLuma(small);
+  for (y = 0; y < height; y++) {
+    memcpy(dst + y * width, luma->imageData + y * luma->widthStep, width);
+  }
+
+  cvReleaseImage(&luma);
+  cvReleaseImage(&small);
+}
+
+// lowest 8x8 frequencies of the DCT of img's luma scaled down to 32x32, in
+// row-major order
+void prismLumaDCT(IplImage* img, double* dst) {
+  int x, y;
+  unsigned char luma[32 * 32];
+  CvMat* pixels = cvCreateMat(32, 32, CV_32FC1);
+  CvMat* coeffs = cvCreateMat(32, 32, CV_32FC1);
+
+  prismLumaThumbnail(img, 32, 32, luma);
+  for (y = 0; y < 32; y++) {
+    float* row = (float*)(pixels->data.ptr + y * pixels->step);
+    for (x = 0; x < 32; x++) {
+      row[x] = luma[y * 32 + x];
+    }
+  }
+
+  cvDCT(pixels, coeffs, CV_DXT_FORWARD);
+
+  for (y = 0; y < 8; y++) {
+    float* row = (float*)(coeffs->data.ptr + y * coeffs->step);
+    for (x = 0; x < 8; x++) {
+      dst[y * 8 + x] = row[x];
+    }
+  }
+
+  cvReleaseMat(&pixels);
+  cvReleaseMat(&coeffs);
+}
+
+// count samples of each channel by value, into counts[channel * 256 + value],
+// in BGR(A) order; 16-bit samples are binned by their high 8 bits
+void prismHistogram(IplImage* img, int* counts) {
+  int x, y, c, channels = img->nChannels;
+
+  for (y = 0; y < img->height; y++) {
+    char* row = img->imageData + y * img->widthStep;
+    for (x = 0; x < img->width; x++) {
+      for (c = 0; c < channels; c++) {
+        counts[c * 256 + sample8(img, row, x * channels + c)]++;
+      }
+    }
+  }
+}
+
+// technical quality of img's luma, scaled down with area interpolation to fit
+// within maxSize x maxSize: sharpness as the variance of its Laplacian,
+// brightness as its mean from 0 - 1, the fractions of samples clipped to black
+// and to white, and noise as the standard deviation estimated by Immerkær's
+// method
+void prismAnalyze(IplImage* img, int maxSize, double* sharpness, double* brightness, double* shadows,
+                  double* highlights, double* noise) {
+  int x, y, width, height;
+  long dark = 0, light = 0;
+  double sum = 0, residuals = 0;
+  CvScalar mean, sdv;
+  IplImage* src = img;
+
+  if (img->width > maxSize || img->height > maxSize) {
+    double scale = (double)maxSize / (img->width > img->height ? img->width : img->height);
+    width = img->width * scale + 0.5;
+    height = img->height * scale + 0.5;
+    src = cvCreateImage(cvSize(width < 1 ? 1 : width, height < 1 ? 1 : height), img->depth, img->nChannels);
+    cvResize(img, src, CV_INTER_AREA);
+  }
+
+  IplImage* luma = prismLuma(src);
+  if (src != img) {
+    cvReleaseImage(&src);
+  }
+  width = luma->width;
+  height = luma->height;
+
+  IplImage* laplacian = cvCreateImage(cvGetSize(luma), IPL_DEPTH_16S, 1);
+  cvLaplace(luma, laplacian, 1);
+  cvAvgSdv(laplacian, &mean, &sdv, NULL);
+  *sharpness = sdv.val[0] * sdv.val[0];
+  cvReleaseImage(&laplacian);
+
+  for (y = 0; y < height; y++) {
+    unsigned char* row = (unsigned char*)(luma->imageData + y * luma->widthStep);
+    for (x = 0; x < width; x++) {
+      sum += row[x];
+      if (row[x] <= 2) {
+        dark++;
+      } else if (row[x] >= 253) {
+        light++;
+      }
+    }
+
+    if (y == 0 || y == height - 1) {
+      continue;
+    }
+
+    // response to the difference of two Laplacians, which cancels out most
+    // image structure and leaves the noise
+    unsigned char* above = row - luma->widthStep;
+    unsigned char* below = row + luma->widthStep;
+    for (x = 1; x < width - 1; x++) {
+      int r = above[x - 1] - 2 * above[x] + above[x + 1] - 2 * row[x - 1] + 4 * row[x] - 2 * row[x + 1] +
+              below[x - 1] - 2 * below[x] + below[x + 1];
+      residuals += r < 0 ? -r : r;
+    }
+  }
+
+  *brightness = sum / ((double)width * height * 255);
+  *shadows = dark / ((double)width * height);
+  *highlights = light / ((double)width * height);
+  *noise = 0;
+  if (width > 2 && height > 2) {
+    *noise = sqrt(CV_PI / 2) * residuals / (6.0 * (width - 2) * (height - 2));
+  }
+
+  cvReleaseImage(&luma);
+}
+
+// whether every alpha sample of img, which has 4 channels, is opaque
+int prismIsOpaque(IplImage* img) {
+  int x, y;
+
+  for (y = 0; y < img->height; y++) {
+    char* row = img->imageData + y * img->widthStep;
+    for (x = 0; x < img->width; x++) {
+      if (img->depth == IPL_DEPTH_16U) {
+        if (((unsigned short*)row)[x * 4 + 3] != 0xffff) {
+          return 0;
+        }
+      } else if (((unsigned char*)row)[x * 4 + 3] != 0xff) {
+        return 0;
+      }
+    }
+  }
+
+  return 1;
+}
+
+// img, which has 4 channels of non-premultiplied samples, composited onto a
+// solid background as 3 channels of the same depth. The background samples
+// are 16-bit for 16-bit images.
+IplImage* prismFlatten(IplImage* img, int b, int g, int r) {
+  int x, y, c;
+  int background[3] = {b, g, r};
+  IplImage* dst = cvCreateImage(cvGetSize(img), img->depth, 3);
+
+  for (y = 0; y < img->height; y++) {
+    char* row = img->imageData + y * img->widthStep;
+    char* out = dst->imageData + y * dst->widthStep;
+
+    if (img->depth == IPL_DEPTH_16U) {
+      unsigned short* src = (unsigned short*)row;
+      unsigned short* px = (unsigned short*)out;
+      for (x = 0; x < img->width; x++) {
+        unsigned long a = src[x * 4 + 3];
+        for (c = 0; c < 3; c++) {
+          px[x * 3 + c] = (src[x * 4 + c] * a + background[c] * (0xffff - a) + 0x7fff) / 0xffff;
+        }
+      }
+    } else {
+      unsigned char* src = (unsigned char*)row;
+      unsigned char* px = (unsigned char*)out;
+      for (x = 0; x < img->width; x++) {
+        unsigned int a = src[x * 4 + 3];
+        for (c = 0; c < 3; c++) {
+          px[x * 3 + c] = (src[x * 4 + c] * a + background[c] * (0xff - a) + 0x7f) / 0xff;
+        }
+      }
+    }
+  }
+
+  return dst;
+}
+
+// mean of the samples of img, which has 4 channels, with each color sample
+// weighted by its alpha. The color means are 0 if every pixel is transparent.
+void prismAverageColor(IplImage* img, double* bgra) {
+  int x, y, c;
+  double sums[3] = {0, 0, 0};
+  double alpha = 0;
+
+  for (y = 0; y < img->height; y++) {
+    char* row = img->imageData + y * img->widthStep;
+    for (x = 0; x < img->width; x++) {
+      double px[4];
+      for (c = 0; c < 4; c++) {
+        px[c] = img->depth == IPL_DEPTH_16U ? ((unsigned short*)row)[x * 4 + c] : ((unsigned char*)row)[x * 4 + c];
+      }
+      for (c = 0; c < 3; c++) {
+        sums[c] += px[c] * px[3];
+      }
+      alpha += px[3];
+    }
+  }
+
+  for (c = 0; c < 3; c++) {
+    bgra[c] = alpha > 0 ? sums[c] / alpha : 0;
+  }
+  bgra[3] = alpha / ((double)img->width * img->height);
+}
+
+// copy of img as 8-bit BGRA, or img itself if it already is
+static IplImage* toBGRA8(IplImage* img) {
+  IplImage* src = img;
+  IplImage* dst;
+
+  if (img->depth == IPL_DEPTH_8U && img->nChannels == 4) {
+    return img;
+  }
+
+  if (img->depth == IPL_DEPTH_16U) {
+    src = cvCreateImage(cvGetSize(img), IPL_DEPTH_8U, img->nChannels);
+    cvConvertScale(img, src, 1.0 / 256, 0);
+    if (img->nChannels == 4) {
+      return src;
+    }
+  }
+
+  dst = cvCreateImage(cvGetSize(img), IPL_DEPTH_8U, 4);
+  cvCvtColor(src, dst, src->nChannels == 1 ? CV_GRAY2BGRA : CV_BGR2BGRA);
+
+  if (src != img) {
+    cvReleaseImage(&src);
+  }
+  return dst;
+}
+
+static inline double sampleAt(IplImage* img, char* row, int i) {
+  if (img->depth == IPL_DEPTH_16U) {
+    return ((unsigned short*)row)[i] / 65535.0;
+  }
+  return ((unsigned char*)row)[i] / 255.0;
+}
+
+static inline void setSample(IplImage* img, char* row, int i, double v) {
+  if (img->depth == IPL_DEPTH_16U) {
+    ((unsigned short*)row)[i] = v * 65535 + 0.5;
+  } else {
+    ((unsigned char*)row)[i] = v * 255 + 0.5;
+  }
+}
+
+// separable blend modes, on samples from 0 - 1
+static inline double blend(int mode, double dst, double src) {
+  switch (mode) {
+  case PRISM_BLEND_MULTIPLY:
+    return dst * src;
+  case PRISM_BLEND_SCREEN:
+    return dst + src - dst * src;
+  case PRISM_BLEND_OVERLAY:
+    return dst <= 0.5 ? 2 * dst * src : 1 - 2 * (1 - dst) * (1 - src);
+  default:
+    return src;
+  }
+}
+
+// blend color, in BGR order from 0 - 1, with alpha srcA over the pixel at
+// sample i of row, combining it with the pixel's color by mode first
+static inline void compositePixel(IplImage* img, char* row, int i, const double* color, double srcA, int mode) {
+  int c, channels = img->nChannels;
+  double dstA = channels == 4 ? sampleAt(img, row, i + 3) : 1;
+  double outA = srcA + dstA * (1 - srcA);
+  double luma;
+
+  if (channels == 1) {
+    luma = 0.114 * color[0] + 0.587 * color[1] + 0.299 * color[2];
+    color = &luma;
+  }
+
+  for (c = 0; c < (channels == 1 ? 1 : 3); c++) {
+    double dst = sampleAt(img, row, i + c);
+    double mixed = (1 - dstA) * color[c] + dstA * blend(mode, dst, color[c]);
+    setSample(img, row, i + c, (mixed * srcA + dst * dstA * (1 - srcA)) / outA);
+  }
+  if (channels == 4) {
+    setSample(img, row, i + 3, outA);
+  }
+}
+
+// composite src, which is 8-bit BGRA, onto img with its top left corner at x,
+// y, clipped to img
+static void compositeBGRA8(IplImage* img, IplImage* src, int x, int y, double opacity, int mode) {
+  int ox, oy;
+
+  int minX = x < 0 ? -x : 0;
+  int minY = y < 0 ? -y : 0;
+  int maxX = src->width < img->width - x ? src->width : img->width - x;
+  int maxY = src->height < img->height - y ? src->height : img->height - y;
+
+  for (oy = minY; oy < maxY; oy++) {
+    unsigned char* in = (unsigned char*)(src->imageData + oy * src->widthStep);
+    char* row = img->imageData + (y + oy) * img->widthStep;
+
+    for (ox = minX; ox < maxX; ox++) {
+      unsigned char* px = in + ox * 4;
+      double srcA = px[3] / 255.0 * opacity;
+      if (srcA > 0) {
+        double color[3] = {px[0] / 255.0, px[1] / 255.0, px[2] / 255.0};
+        compositePixel(img, row, (x + ox) * img->nChannels, color, srcA, mode);
+      }
+    }
+  }
+}
+
+// composite overlay onto img with its top left corner at x, y, clipped to img.
+// The overlay's alpha is scaled by opacity and its colors are combined with
+// img's by mode, then alpha blended over them; onto gray images, its luma is.
+void prismComposite(IplImage* img, IplImage* overlay, int x, int y, double opacity, int mode) {
+  IplImage* src = toBGRA8(overlay);
+
+  compositeBGRA8(img, src, x, y, opacity, mode);
+
+  if (src != overlay) {
+    cvReleaseImage(&src);
+  }
+}
+
+// composite overlay like prismComposite, repeated across img from a tile with
+// its top left corner at x, y, which must be at or before img's
+void prismCompositeTiled(IplImage* img, IplImage* overlay, int x, int y, double opacity, int mode) {
+  int tx, ty;
+  IplImage* src = toBGRA8(overlay);
+
+  for (ty = y; ty < img->height; ty += src->height) {
+    for (tx = x; tx < img->width; tx += src->width) {
+      compositeBGRA8(img, src, tx, ty, opacity, mode);
+    }
+  }
+
+  if (src != overlay) {
+    cvReleaseImage(&src);
+  }
+}
+
+// composite a solid color, with 8-bit samples in BGRA order, onto img through
+// a width x height mask of 8-bit alpha, with its top left corner at x, y,
+// clipped to img
+void prismCompositeMask(IplImage* img, unsigned char* mask, int width, int height, int stride, int x, int y,
+                        const unsigned char* bgra) {
+  int mx, my;
+  double color[3] = {bgra[0] / 255.0, bgra[1] / 255.0, bgra[2] / 255.0};
+  double alpha = bgra[3] / 255.0;
+
+  int minX = x < 0 ? -x : 0;
+  int minY = y < 0 ? -y : 0;
+  int maxX = width < img->width - x ? width : img->width - x;
+  int maxY = height < img->height - y ? height : img->height - y;
+
+  for (my = minY; my < maxY; my++) {
+    unsigned char* in = mask + my * stride;
+    char* row = img->imageData + (y + my) * img->widthStep;
+
+    for (mx = minX; mx < maxX; mx++) {
+      if (in[mx]) {
+        compositePixel(img, row, (x + mx) * img->nChannels, color, in[mx] / 255.0 * alpha, PRISM_BLEND_NORMAL);
+      }
+    }
+  }
+}
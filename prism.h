@@ -13,11 +13,53 @@ typedef struct {
   CvMat* _mat;
 } PrismEncoded;
 
-PrismEncoded* prismEncodeJPEG(IplImage* img, int quality);
 PrismEncoded* prismEncodePNG(IplImage* img, int compression);
 
+unsigned long prismJPEGBufSize(IplImage* img);
+unsigned long prismEncodeJPEGInto(tjhandle jpeg, IplImage* img, int quality, unsigned char* buffer);
+
 void prismRelease(PrismEncoded* enc);
 
-IplImage* prismDecode(void* data, unsigned int dataSize);
+IplImage* prismDecode(tjhandle jpeg, void* data, unsigned int dataSize, int minWidth, int minHeight);
+void prismScaledSize(int* width, int* height, int minWidth, int minHeight);
+
+void prismToNRGBA(IplImage* img, unsigned char* dst, int dstStride);
+void prismToGray(IplImage* img, unsigned char* dst, int dstStride);
+
+IplImage* prismView(IplImage* img, int x, int y, int width, int height);
+IplImage* prismCrop(IplImage* img, int x, int y, int width, int height);
+
+IplImage* prismFromBuffer(unsigned char* src, int width, int height, int srcStride, int channels, int depth, int swapRB);
+IplImage* prismFromRGBA(unsigned char* src, int width, int height, int srcStride);
+IplImage* prismFromYCbCr(unsigned char* y, int yStride, unsigned char* cb, unsigned char* cr, int cStride,
+                         int width, int height, int minX, int minY, int hShift, int vShift);
+
+IplImage* prismLuma(IplImage* img);
+IplImage* prismDecodeLuma(tjhandle jpeg, void* data, unsigned long dataSize);
+double prismSSIM(IplImage* a, IplImage* b);
+int prismCompare(IplImage* a, IplImage* b, double* mse, double* ssim);
+
+void prismLumaThumbnail(IplImage* img, int width, int height, unsigned char* dst);
+void prismLumaDCT(IplImage* img, double* dst);
+
+void prismHistogram(IplImage* img, int* counts);
+void prismAnalyze(IplImage* img, int maxSize, double* sharpness, double* brightness, double* shadows,
+                  double* highlights, double* noise);
+
+int prismIsOpaque(IplImage* img);
+IplImage* prismFlatten(IplImage* img, int b, int g, int r);
+void prismAverageColor(IplImage* img, double* bgra);
+
+enum {
+  PRISM_BLEND_NORMAL,
+  PRISM_BLEND_MULTIPLY,
+  PRISM_BLEND_SCREEN,
+  PRISM_BLEND_OVERLAY,
+};
+
+void prismComposite(IplImage* img, IplImage* overlay, int x, int y, double opacity, int mode);
+void prismCompositeTiled(IplImage* img, IplImage* overlay, int x, int y, double opacity, int mode);
+void prismCompositeMask(IplImage* img, unsigned char* mask, int width, int height, int stride, int x, int y,
+                        const unsigned char* bgra);
 
 #endif
@@ -0,0 +1,273 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sync"
+)
+
+// ErrProcessorClosed is returned for jobs submitted to a closed Processor
+var ErrProcessorClosed = errors.New("Processor has been closed")
+
+// Processor runs pipelines on a fixed pool of workers.
+//
+// Decoded images live on the C heap, out of sight of Go's garbage collector,
+// so a Processor also limits the C heap memory its jobs use. Each job's
+// footprint is estimated from the image header before decoding, and jobs are
+// admitted in order only while the total stays within the memory limit. A job
+// larger than the limit runs on its own.
+type Processor struct {
+	jobs   chan *job
+	quit   chan struct{}
+	wg     sync.WaitGroup
+	closer sync.Once
+
+	limit   int64
+	m       sync.Mutex
+	inUse   int64
+	waiting []*waiter // jobs waiting for memory, in order of arrival
+}
+
+// waiter is a job waiting for size bytes of memory, whose ready channel is
+// closed once they are admitted
+type waiter struct {
+	size  int64
+	ready chan struct{}
+}
+
+// Job is a pipeline to run on the image read from Input, writing to Output
+type Job struct {
+	Pipeline *Pipeline
+	Input    io.Reader
+	Output   io.Writer
+}
+
+type job struct {
+	ctx      context.Context
+	pipeline *Pipeline
+	b        []byte
+	w        io.Writer
+	done     chan error
+}
+
+// NewProcessor starts a Processor with the given number of workers, whose jobs
+// are limited to memoryLimit bytes of C heap in total, or unlimited if 0
+func NewProcessor(workers int, memoryLimit int64) *Processor {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &Processor{
+		jobs:  make(chan *job),
+		quit:  make(chan struct{}),
+		limit: memoryLimit,
+	}
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// Process reads an image from r, applies pipeline and writes the result to w,
+// as Pipeline.Process does, once a worker and enough memory are available. It
+// may be called from several goroutines.
+func (p *Processor) Process(pipeline *Pipeline, w io.Writer, r io.Reader) error {
+	return p.ProcessContext(context.Background(), pipeline, w, r)
+}
+
+// ProcessContext is like Process, but gives up with ctx.Err() if ctx is done
+// while waiting for a worker or memory, or between stages of the pipeline
+func (p *Processor) ProcessContext(ctx context.Context, pipeline *Pipeline, w io.Writer, r io.Reader) error {
+	buf, err := readAll(r)
+	if err != nil {
+		return err
+	}
+	defer putBuffer(buf)
+
+	j := &job{ctx, pipeline, buf.Bytes(), w, make(chan error, 1)}
+
+	select {
+	case p.jobs <- j:
+		return <-j.done
+	case <-p.quit:
+		return ErrProcessorClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ProcessAll runs jobs concurrently and returns the error of each
+func (p *Processor) ProcessAll(jobs []Job) []error {
+	errs := make([]error, len(jobs))
+
+	var wg sync.WaitGroup
+	wg.Add(len(jobs))
+	for i, j := range jobs {
+		go func(i int, j Job) {
+			defer wg.Done()
+			errs[i] = p.Process(j.Pipeline, j.Output, j.Input)
+		}(i, j)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// MemoryInUse returns the estimated C heap memory of the jobs running
+func (p *Processor) MemoryInUse() int64 {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.inUse
+}
+
+// Close stops the workers once the jobs running have finished. Jobs submitted
+// afterwards fail with ErrProcessorClosed.
+func (p *Processor) Close() {
+	p.closer.Do(func() {
+		close(p.quit)
+	})
+	p.wg.Wait()
+}
+
+func (p *Processor) work() {
+	defer p.wg.Done()
+
+	// each worker reuses its own encode buffer
+	enc := NewEncoder()
+	defer enc.Close()
+
+	for {
+		select {
+		case j := <-p.jobs:
+			j.done <- p.run(j, enc)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *Processor) run(j *job, enc *Encoder) error {
+	cfg, format, orientation, err := decodeHeader(j.b)
+	if err != nil {
+		return err
+	}
+
+	plan, err := j.pipeline.Plan(cfg.Width, cfg.Height, orientation)
+	if err != nil {
+		return err
+	}
+
+	size := memoryEstimate(cfg, format, plan)
+	if err = p.acquire(j.ctx, size); err != nil {
+		return err
+	}
+	defer p.release(size)
+
+	return j.pipeline.process(j.ctx, j.w, j.b, plan, enc)
+}
+
+func (p *Processor) acquire(ctx context.Context, size int64) error {
+	p.m.Lock()
+	if len(p.waiting) == 0 && p.fits(size) {
+		p.inUse += size
+		p.m.Unlock()
+		return nil
+	}
+	w := &waiter{size, make(chan struct{})}
+	p.waiting = append(p.waiting, w)
+	p.m.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	select {
+	case <-w.ready:
+		// admitted meanwhile, so give the memory back
+		p.inUse -= size
+	default:
+		for i, other := range p.waiting {
+			if other == w {
+				p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	p.admitWaiting()
+
+	return ctx.Err()
+}
+
+func (p *Processor) release(size int64) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.inUse -= size
+	p.admitWaiting()
+}
+
+// fits reports whether a job of the given size can be admitted now; a job
+// larger than the limit is admitted once nothing else is running
+func (p *Processor) fits(size int64) bool {
+	return p.limit <= 0 || p.inUse == 0 || p.inUse+size <= p.limit
+}
+
+// admitWaiting admits waiting jobs in order while they fit. p.m must be held.
+func (p *Processor) admitWaiting() {
+	for len(p.waiting) > 0 && p.fits(p.waiting[0].size) {
+		w := p.waiting[0]
+		p.waiting = p.waiting[1:]
+		p.inUse += w.size
+		close(w.ready)
+	}
+}
+
+// rough estimate of the C heap memory used to execute plan on an image with
+// the given header: the decoded source, plus the resized and oriented result
+// and its encoding. Cropped regions are resized straight from the source, so
+// they add nothing.
+func memoryEstimate(cfg image.Config, format string, plan *Plan) int64 {
+	pixel := int64(bytesPerPixel(cfg.ColorModel))
+
+	width, height := C.int(cfg.Width), C.int(cfg.Height)
+	if format == "jpeg" {
+		decodeW, decodeH := plan.DecodeSize()
+		C.prismScaledSize(&width, &height, C.int(decodeW), C.int(decodeH))
+	}
+	out := plan.Bounds()
+
+	return int64(width)*int64(height)*pixel + 3*int64(out.Dx()*out.Dy())*pixel
+}
+
+// bytes per pixel of an image with the given color model once decoded
+func bytesPerPixel(model color.Model) int {
+	switch model {
+	case color.GrayModel:
+		return 1
+	case color.Gray16Model:
+		return 2
+	case color.RGBAModel, color.NRGBAModel:
+		return 4
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 8
+	}
+	return 3
+}
@@ -0,0 +1,136 @@
+package prism
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessor(t *testing.T) {
+	p := NewProcessor(2, 1<<20)
+	defer p.Close()
+
+	pipeline, _ := ParsePipeline("fit=100x100")
+	jobs := make([]Job, 6)
+	outputs := make([]bytes.Buffer, len(jobs))
+	for i := range jobs {
+		jobs[i] = Job{pipeline, bytes.NewBuffer(lennaJPG), &outputs[i]}
+	}
+
+	for i, err := range p.ProcessAll(jobs) {
+		assert.Nil(t, err)
+
+		cfg, _, err := image.DecodeConfig(&outputs[i])
+		assert.Nil(t, err)
+		assert.Equal(t, 100, cfg.Width)
+	}
+	assert.Equal(t, int64(0), p.MemoryInUse())
+}
+
+func TestProcessorError(t *testing.T) {
+	p := NewProcessor(1, 0)
+
+	pipeline, _ := ParsePipeline("fit=100x100")
+	var buf bytes.Buffer
+	assert.NotNil(t, p.Process(pipeline, &buf, bytes.NewBufferString("not an image")))
+
+	p.Close()
+	assert.Equal(t, ErrProcessorClosed, p.Process(pipeline, &buf, bytes.NewBuffer(lennaJPG)))
+}
+
+func TestProcessorMemoryLimit(t *testing.T) {
+	p := NewProcessor(1, 100)
+	defer p.Close()
+
+	// a job larger than the limit is admitted on its own
+	p.acquire(context.Background(), 1000)
+
+	admitted := make(chan bool)
+	go func() {
+		p.acquire(context.Background(), 10)
+		admitted <- true
+	}()
+
+	select {
+	case <-admitted:
+		t.Fatal("admitted beyond memory limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.release(1000)
+	<-admitted
+	assert.Equal(t, int64(10), p.MemoryInUse())
+}
+
+func TestMemoryEstimate(t *testing.T) {
+	pipeline, _ := ParsePipeline("fit=100x100")
+	plan, _ := pipeline.Plan(512, 512, 1)
+
+	// JPEG decoded at 128x128, resized to 100x100
+	cfg := image.Config{ColorModel: color.YCbCrModel, Width: 512, Height: 512}
+	assert.Equal(t, int64(128*128*3+3*100*100*3), memoryEstimate(cfg, "jpeg", plan))
+
+	cfg.ColorModel = color.GrayModel
+	assert.Equal(t, int64(128*128+3*100*100), memoryEstimate(cfg, "jpeg", plan))
+
+	cfg.ColorModel = color.NRGBAModel
+	assert.Equal(t, int64(512*512*4+3*100*100*4), memoryEstimate(cfg, "png", plan))
+}
+
+func TestProcessorContext(t *testing.T) {
+	p := NewProcessor(1, 100)
+	defer p.Close()
+
+	p.acquire(context.Background(), 1000)
+	defer p.release(1000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	pipeline, _ := ParsePipeline("fit=100x100")
+	var buf bytes.Buffer
+	assert.Equal(t, context.DeadlineExceeded, p.ProcessContext(ctx, pipeline, &buf, bytes.NewBuffer(lennaJPG)))
+}
+
+func TestProcessorContextQueued(t *testing.T) {
+	p := NewProcessor(2, 100)
+	defer p.Close()
+
+	p.acquire(context.Background(), 1000)
+
+	admitted := make(chan bool)
+	go func() {
+		p.acquire(context.Background(), 10)
+		admitted <- true
+	}()
+	for queued := 0; queued < 1; {
+		time.Sleep(time.Millisecond)
+		p.m.Lock()
+		queued = len(p.waiting)
+		p.m.Unlock()
+	}
+
+	// a job queued behind another gives up as soon as ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		canceled <- p.acquire(ctx, 10)
+	}()
+	cancel()
+
+	select {
+	case err := <-canceled:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("queued job not canceled")
+	}
+
+	p.release(1000)
+	<-admitted
+	assert.Equal(t, int64(10), p.MemoryInUse())
+}
@@ -0,0 +1,182 @@
+package prism
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"sort"
+	"sync"
+)
+
+// Rendition is an encoded output of Renditions
+type Rendition struct {
+	Pipeline *Pipeline
+	Width    int
+	Height   int
+	Bytes    []byte
+}
+
+// RenditionError reports the spec that failed in Renditions
+type RenditionError struct {
+	Index int
+	Err   error
+}
+
+func (e *RenditionError) Error() string {
+	return fmt.Sprintf("Rendition %d: %v", e.Index, e.Err)
+}
+
+// Renditions applies each of specs to img, which is left untouched, and
+// returns the encoded results in the same order.
+//
+// Specs cropping the same region of img are resized progressively, each from
+// the next larger size rather than from the original, with area interpolation.
+// If parallel is true, rotations, flips and encoding run concurrently.
+func Renditions(img *Image, specs []*Pipeline, parallel bool) ([]*Rendition, error) {
+	return RenditionsContext(context.Background(), img, specs, parallel)
+}
+
+// RenditionsContext is like Renditions, but gives up with ctx.Err() between
+// renditions once ctx is done
+func RenditionsContext(ctx context.Context, img *Image, specs []*Pipeline, parallel bool) ([]*Rendition, error) {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, ErrReleased
+	}
+	orientation := img.Orientation()
+
+	plans := make([]*Plan, len(specs))
+	groups := map[region][]int{}
+	var regions []region
+
+	for i, spec := range specs {
+		plan, err := spec.Plan(bounds.Dx(), bounds.Dy(), orientation)
+		if err != nil {
+			return nil, &RenditionError{i, err}
+		}
+		plans[i] = plan
+
+		if _, ok := groups[plan.region]; !ok {
+			regions = append(regions, plan.region)
+		}
+		groups[plan.region] = append(groups[plan.region], i)
+	}
+
+	renditions := make([]*Rendition, len(specs))
+	errs := make([]error, len(specs))
+
+	var wg sync.WaitGroup
+	var resized []*Image
+	defer func() {
+		wg.Wait()
+		for _, r := range resized {
+			r.Release()
+		}
+	}()
+
+	for _, key := range regions {
+		indexes := groups[key]
+		sort.Sort(bySize{indexes, plans})
+
+		var prev *Image
+		for _, i := range indexes {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			plan := plans[i]
+
+			// resize from the previous, larger rendition when it is big enough
+			var current *Image
+			var err error
+			if prev != nil && prev.Bounds().Dx() >= plan.width && prev.Bounds().Dy() >= plan.height {
+				current, err = resizedTo(prev, prev.Bounds(), plan.width, plan.height)
+			} else {
+				var r image.Rectangle
+				if r, err = plan.sourceRect(bounds); err == nil {
+					current, err = resizedTo(img, r, plan.width, plan.height)
+				}
+			}
+			if err != nil {
+				return nil, &RenditionError{i, err}
+			}
+
+			resized = append(resized, current)
+			prev = current
+
+			if parallel {
+				wg.Add(1)
+				go func(i int, current *Image) {
+					defer wg.Done()
+					renditions[i], errs[i] = encodeRendition(specs[i], plans[i], current)
+				}(i, current)
+			} else {
+				renditions[i], errs[i] = encodeRendition(specs[i], plans[i], current)
+			}
+		}
+	}
+
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for i, err := range errs {
+		if err != nil {
+			return nil, &RenditionError{i, err}
+		}
+	}
+
+	return renditions, nil
+}
+
+// resizedTo returns the r portion of img resized to width x height, as a view
+// of img if no resizing is needed
+func resizedTo(img *Image, r image.Rectangle, width, height int) (*Image, error) {
+	view := img.SubImage(r).(*Image)
+	if view.Bounds().Empty() {
+		return nil, ErrReleased
+	}
+
+	if r.Dx() == width && r.Dy() == height {
+		return view, nil
+	}
+	defer view.Release()
+
+	return view.Resized(width, height)
+}
+
+// encodeRendition orients img, already cropped and resized by plan, and
+// encodes it with spec's output options
+func encodeRendition(spec *Pipeline, plan *Plan, img *Image) (*Rendition, error) {
+	out := img
+	if plan.orient != (orientation{}) {
+		var err error
+		if out, err = img.derive(plan.oriented); err != nil {
+			return nil, err
+		}
+		defer out.Release()
+	}
+
+	var buf bytes.Buffer
+	if err := spec.Encode(&buf, out); err != nil {
+		return nil, err
+	}
+
+	bounds := out.Bounds()
+	return &Rendition{spec, bounds.Dx(), bounds.Dy(), buf.Bytes()}, nil
+}
+
+// sorts indexes of plans from the largest resized size to the smallest
+type bySize struct {
+	indexes []int
+	plans   []*Plan
+}
+
+func (s bySize) Len() int { return len(s.indexes) }
+
+func (s bySize) Swap(i, j int) { s.indexes[i], s.indexes[j] = s.indexes[j], s.indexes[i] }
+
+func (s bySize) Less(i, j int) bool {
+	a, b := s.plans[s.indexes[i]], s.plans[s.indexes[j]]
+	return a.width*a.height > b.width*b.height
+}
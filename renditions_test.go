@@ -0,0 +1,127 @@
+package prism
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func renditionSpecs(specs ...string) []*Pipeline {
+	pipelines := make([]*Pipeline, len(specs))
+	for i, spec := range specs {
+		pipelines[i], _ = ParsePipeline(spec)
+	}
+	return pipelines
+}
+
+func TestRenditions(t *testing.T) {
+	img := testImg("lenna.jpg")
+	specs := renditionSpecs("fit=128x128", "fit=256x256,fmt=png", "fit=64x64,rotate=90", "crop=100x50+0+0")
+
+	for _, parallel := range []bool{false, true} {
+		renditions, err := Renditions(img, specs, parallel)
+		assert.Nil(t, err)
+		assert.Len(t, renditions, 4)
+
+		for i, size := range []image.Point{{128, 128}, {256, 256}, {64, 64}, {100, 50}} {
+			assert.Equal(t, specs[i], renditions[i].Pipeline)
+			assert.Equal(t, size.X, renditions[i].Width)
+			assert.Equal(t, size.Y, renditions[i].Height)
+
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(renditions[i].Bytes))
+			assert.Nil(t, err)
+			assert.Equal(t, size, image.Pt(cfg.Width, cfg.Height))
+			if i == 1 {
+				assert.Equal(t, "png", format)
+			} else {
+				assert.Equal(t, "jpeg", format)
+			}
+		}
+	}
+
+	// the source is left untouched
+	assert.Equal(t, image.Rect(0, 0, 512, 512), img.Bounds())
+}
+
+func TestRenditionsLargestMatchesExecute(t *testing.T) {
+	img := testImg("mlk.png")
+	specs := renditionSpecs("fit=100x100,fmt=png", "fit=400x400,fmt=png")
+
+	renditions, err := Renditions(img, specs, true)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, specs[1].Execute(&buf, img))
+	assert.Equal(t, buf.Bytes(), renditions[1].Bytes)
+}
+
+func TestRenditionsError(t *testing.T) {
+	img := testImg("lenna.jpg")
+	specs := renditionSpecs("fit=128x128", "crop=10x10+1000+1000")
+
+	_, err := Renditions(img, specs, false)
+	assert.IsType(t, &RenditionError{}, err)
+	assert.Equal(t, 1, err.(*RenditionError).Index)
+
+	img.Release()
+	_, err = Renditions(img, specs, false)
+	assert.Equal(t, ErrReleased, err)
+}
+
+// cancelAfter is a context that is done once Err has been called n times
+type cancelAfter struct {
+	context.Context
+	n, calls int
+}
+
+func (c *cancelAfter) Err() error {
+	c.calls++
+	if c.calls > c.n {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestRenditionsContextCanceled(t *testing.T) {
+	img := testImg("lenna.jpg")
+	defer img.Release()
+	specs := renditionSpecs("fit=256x256", "fit=128x128", "crop=100x50+0+0")
+
+	for _, parallel := range []bool{false, true} {
+		verify := VerifyReleased(t)
+
+		// done after the first rendition is started
+		ctx := &cancelAfter{Context: context.Background(), n: 1}
+		renditions, err := RenditionsContext(ctx, img, specs, parallel)
+		assert.Nil(t, renditions)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 2, ctx.calls)
+
+		// the partial renditions are released
+		verify()
+	}
+}
+
+func BenchmarkRenditions(b *testing.B) {
+	img, _ := Decode(bytes.NewBuffer(lennaJPG))
+	specs := renditionSpecs("fit=512x512", "fit=256x256", "fit=128x128", "fit=64x64")
+
+	for n := 0; n < b.N; n++ {
+		Renditions(img, specs, true)
+	}
+}
+
+func BenchmarkRenditionsSequential(b *testing.B) {
+	img, _ := Decode(bytes.NewBuffer(lennaJPG))
+	specs := renditionSpecs("fit=512x512", "fit=256x256", "fit=128x128", "fit=64x64")
+
+	for n := 0; n < b.N; n++ {
+		for _, spec := range specs {
+			spec.Execute(ioutil.Discard, img)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"sync/atomic"
+)
+
+// MemoryStats describes the C heap memory held by prism: the pixels of decoded
+// and transformed images, and the buffers of encoded output while it is being
+// written. Short-lived allocations inside OpenCV and libjpeg-turbo, such as
+// intermediate results of a single transform, are not included.
+type MemoryStats struct {
+	LiveImages        int64 // pixel buffers not yet freed
+	LiveBytes         int64 // bytes not yet freed
+	PeakBytes         int64 // highest value of LiveBytes
+	TotalAllocated    int64 // bytes allocated since the process started
+	Released          int64 // images and sub-images freed by Release
+	FinalizerReleased int64 // images and sub-images freed by the garbage collector
+}
+
+var stats MemoryStats
+
+// Stats returns a snapshot of prism's C heap usage
+func Stats() MemoryStats {
+	return MemoryStats{
+		LiveImages:        atomic.LoadInt64(&stats.LiveImages),
+		LiveBytes:         atomic.LoadInt64(&stats.LiveBytes),
+		PeakBytes:         atomic.LoadInt64(&stats.PeakBytes),
+		TotalAllocated:    atomic.LoadInt64(&stats.TotalAllocated),
+		Released:          atomic.LoadInt64(&stats.Released),
+		FinalizerReleased: atomic.LoadInt64(&stats.FinalizerReleased),
+	}
+}
+
+func trackAlloc(size int64) {
+	atomic.AddInt64(&stats.TotalAllocated, size)
+	live := atomic.AddInt64(&stats.LiveBytes, size)
+
+	for {
+		peak := atomic.LoadInt64(&stats.PeakBytes)
+		if live <= peak || atomic.CompareAndSwapInt64(&stats.PeakBytes, peak, live) {
+			return
+		}
+	}
+}
+
+func trackFree(size int64) {
+	atomic.AddInt64(&stats.LiveBytes, -size)
+}
+
+func trackImageAlloc(iplImage *C.IplImage) int64 {
+	size := int64(iplImage.imageSize)
+	atomic.AddInt64(&stats.LiveImages, 1)
+	trackAlloc(size)
+	return size
+}
+
+func trackImageFree(size int64) {
+	atomic.AddInt64(&stats.LiveImages, -1)
+	trackFree(size)
+}
@@ -0,0 +1,54 @@
+package prism
+
+import (
+	"bytes"
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	before := Stats()
+
+	img, _ := Decode(bytes.NewBuffer(lennaJPG))
+	view := img.SubImage(img.Bounds().Inset(10)).(*Image)
+	during := Stats()
+
+	assert.True(t, during.TotalAllocated-before.TotalAllocated >= 512*512*3)
+	assert.True(t, during.PeakBytes >= during.LiveBytes)
+
+	view.Release()
+	img.Release()
+	img.Release()
+	after := Stats()
+
+	assert.True(t, after.Released-before.Released >= 2)
+	assert.Equal(t, during.TotalAllocated, after.TotalAllocated)
+}
+
+func TestStatsEncode(t *testing.T) {
+	img, _ := Decode(bytes.NewBuffer(lennaJPG))
+	defer img.Release()
+
+	before := Stats()
+	EncodeJPEG(ioutil.Discard, img, 85)
+	after := Stats()
+
+	assert.True(t, after.TotalAllocated > before.TotalAllocated)
+}
+
+func TestStatsFinalizer(t *testing.T) {
+	before := Stats()
+
+	Decode(bytes.NewBuffer(lennaJPG))
+
+	for i := 0; i < 100 && Stats().FinalizerReleased == before.FinalizerReleased; i++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.True(t, Stats().FinalizerReleased > before.FinalizerReleased)
+}
@@ -0,0 +1,195 @@
+package prism
+
+//#cgo pkg-config: --libs-only-L opencv libturbojpeg
+//#cgo CFLAGS: -O3 -Wno-error=unused-function
+//#cgo LDFLAGS: -lopencv_imgproc -lopencv_core -lopencv_highgui -lturbojpeg
+//#include "prism.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"unsafe"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// default distance between baselines, as a multiple of the font size
+const defaultLineHeight = 1.2
+
+// Font is a parsed TrueType font, which may be shared between goroutines
+type Font struct {
+	font *truetype.Font
+}
+
+// ParseFont parses a TrueType font, or an OpenType font with TrueType
+// outlines. Fonts with PostScript (CFF) outlines are not supported.
+func ParseFont(b []byte) (*Font, error) {
+	f, err := truetype.Parse(b)
+	if err != nil {
+		return nil, err
+	}
+	return &Font{f}, nil
+}
+
+// TextAlign aligns the lines of a block of text with each other
+type TextAlign string
+
+const (
+	AlignLeft   TextAlign = "left"
+	AlignCenter TextAlign = "center"
+	AlignRight  TextAlign = "right"
+)
+
+// TextOptions control how DrawText lays out and colors text
+type TextOptions struct {
+	Font *Font
+
+	// Size is the height of the font's em square in pixels
+	Size float64
+
+	// Color of the text, including its opacity; nil for black
+	Color color.Color
+
+	// Align aligns lines within the block of text; empty for AlignLeft
+	Align TextAlign
+
+	// LineHeight is the distance between baselines, as a multiple of Size; 0
+	// for 1.2
+	LineHeight float64
+
+	// Gravity places the block of text within the image; empty for
+	// GravityCenter
+	Gravity Gravity
+
+	// Offset moves the text away from the edges Gravity places it against, or
+	// right and down from the center
+	Offset image.Point
+}
+
+// DrawText draws text onto img, with lines separated by "\n". Glyphs are
+// rasterized in Go into an alpha mask, which is blended onto img in its
+// color; onto gray images, the color's luma is drawn.
+func (img *Image) DrawText(text string, opts TextOptions) (err error) {
+	if opts.Font == nil {
+		return errors.New("No font given")
+	}
+	if opts.Size <= 0 {
+		return fmt.Errorf("Invalid font size: %v", opts.Size)
+	}
+	if opts.LineHeight < 0 {
+		return fmt.Errorf("Invalid line height: %v", opts.LineHeight)
+	}
+	switch opts.Align {
+	case "", AlignLeft, AlignCenter, AlignRight:
+	default:
+		return fmt.Errorf("Invalid alignment: %q", opts.Align)
+	}
+	if _, ok := gravityOffsets[opts.Gravity]; !ok && opts.Gravity != "" {
+		return fmt.Errorf("Invalid gravity: %q", opts.Gravity)
+	}
+
+	textColor := opts.Color
+	if textColor == nil {
+		textColor = color.Black
+	}
+	c := color.NRGBAModel.Convert(textColor).(color.NRGBA)
+	bgra := [4]C.uchar{C.uchar(c.B), C.uchar(c.G), C.uchar(c.R), C.uchar(c.A)}
+
+	// rasterize before locking img; a released image has no size, so nothing
+	// is rasterized
+	at, mask := renderText(text, opts, img.Bounds().Size())
+
+	img.m.Lock()
+	defer img.m.Unlock()
+	defer recoverWithError(&err)
+
+	if img.iplImage == nil {
+		return ErrReleased
+	}
+
+	if mask.Rect.Empty() || c.A == 0 {
+		return nil
+	}
+
+	at = at.Add(mask.Rect.Min)
+	C.prismCompositeMask(
+		img.iplImage,
+		(*C.uchar)(unsafe.Pointer(&mask.Pix[0])),
+		C.int(mask.Rect.Dx()), C.int(mask.Rect.Dy()), C.int(mask.Stride),
+		C.int(at.X), C.int(at.Y),
+		&bgra[0],
+	)
+
+	return nil
+}
+
+// renderText lays out the lines of text in a box from the origin, whose
+// width is that of the longest line, and places the box within an image of the
+// given size at at. It rasterizes the lines into a mask covering both the box
+// and any glyphs that overhang it, clipped to the image, which is empty if
+// none of the text lands on the image.
+func renderText(text string, opts TextOptions, size image.Point) (at image.Point, mask *image.Alpha) {
+	face := truetype.NewFace(opts.Font.font, &truetype.Options{Size: opts.Size, DPI: 72})
+	defer face.Close()
+
+	lineHeight := opts.LineHeight
+	if lineHeight == 0 {
+		lineHeight = defaultLineHeight
+	}
+	step := int(math.Ceil(opts.Size * lineHeight))
+	metrics := face.Metrics()
+	ascent, descent := metrics.Ascent.Ceil(), metrics.Descent.Ceil()
+
+	var layout image.Rectangle
+	lines := strings.Split(text, "\n")
+	widths := make([]int, len(lines))
+	for i, line := range lines {
+		widths[i] = font.MeasureString(face, line).Ceil()
+		if widths[i] > layout.Max.X {
+			layout.Max.X = widths[i]
+		}
+	}
+	layout.Max.Y = ascent + descent + (len(lines)-1)*step
+
+	// place each line's baseline, and find the bounds of its glyphs there
+	dots := make([]fixed.Point26_6, len(lines))
+	bounds := layout
+	for i, line := range lines {
+		x := 0
+		switch opts.Align {
+		case AlignCenter:
+			x = (layout.Max.X - widths[i]) / 2
+		case AlignRight:
+			x = layout.Max.X - widths[i]
+		}
+		dots[i] = fixed.P(x, ascent+i*step)
+
+		ink, _ := font.BoundString(face, line)
+		ink = ink.Add(dots[i])
+		bounds = bounds.Union(image.Rect(ink.Min.X.Floor(), ink.Min.Y.Floor(), ink.Max.X.Ceil(), ink.Max.Y.Ceil()))
+	}
+
+	// clip to the image, in layout coordinates
+	at = opts.Gravity.Position(image.Rectangle{Max: size}, layout.Size()).Min
+	at = at.Add(gravityOffset(opts.Gravity, opts.Offset))
+	bounds = bounds.Intersect(image.Rectangle{Max: size}.Sub(at))
+	if bounds.Empty() {
+		return at, &image.Alpha{}
+	}
+
+	mask = image.NewAlpha(bounds)
+	drawer := font.Drawer{Dst: mask, Src: image.Opaque, Face: face}
+	for i, line := range lines {
+		drawer.Dot = dots[i]
+		drawer.DrawString(line)
+	}
+
+	return at, mask
+}
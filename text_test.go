@@ -0,0 +1,133 @@
+package prism
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFont() *Font {
+	b, err := ioutil.ReadFile("./testdata/Go-Regular.ttf")
+	if err != nil {
+		panic(err)
+	}
+	f, err := ParseFont(b)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// bounds of the pixels of img that are not black
+func inkBounds(img *Image) image.Rectangle {
+	var ink image.Rectangle
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if r, g, b, _ := img.At(x, y).RGBA(); r|g|b != 0 {
+				ink = ink.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return ink
+}
+
+func TestDrawText(t *testing.T) {
+	img := uniformImg(200, 60, 3, color.Black)
+
+	err := img.DrawText("Hello", TextOptions{Font: testFont(), Size: 24, Color: color.White})
+	assert.Nil(t, err)
+
+	ink := inkBounds(img)
+	assert.False(t, ink.Empty())
+	assert.InDelta(t, 100, (ink.Min.X+ink.Max.X)/2, 3)
+	assert.InDelta(t, 30, (ink.Min.Y+ink.Max.Y)/2, 6)
+	assert.InDelta(t, 20, ink.Dy(), 2)
+
+	gray := uniformImg(200, 60, 1, color.Gray{0})
+	gray.DrawText("Hello", TextOptions{Font: testFont(), Size: 24, Color: color.White})
+	assert.Equal(t, ink, inkBounds(gray))
+}
+
+func TestDrawTextLayout(t *testing.T) {
+	font := testFont()
+
+	img := uniformImg(200, 100, 3, color.Black)
+	img.DrawText("Top left", TextOptions{Font: font, Size: 16, Color: red, Gravity: GravityNorthWest, Offset: image.Pt(5, 5)})
+	ink := inkBounds(img)
+	assert.InDelta(t, 6, ink.Min.X, 2)
+	assert.InDelta(t, 8, ink.Min.Y, 3)
+	for y := ink.Min.Y; y < ink.Max.Y; y++ {
+		for x := ink.Min.X; x < ink.Max.X; x++ {
+			_, g, b, _ := img.At(x, y).RGBA()
+			assert.Equal(t, uint32(0), g|b)
+		}
+	}
+
+	img = uniformImg(200, 100, 3, color.Black)
+	img.DrawText("wide line\nI", TextOptions{Font: font, Size: 16, Color: color.White, Gravity: GravityEast, Align: AlignRight})
+	ink = inkBounds(img)
+	assert.InDelta(t, 199, ink.Max.X, 3)
+
+	// the "I" alone is drawn on the second line, at the right edge
+	second := img.SubImage(image.Rect(0, 50, 200, 100)).(*Image)
+	lineInk := inkBounds(second)
+	assert.True(t, lineInk.Min.Y >= 50)
+	assert.InDelta(t, 199, lineInk.Max.X, 3)
+	assert.True(t, lineInk.Dx() < 10)
+
+	// fully transparent text draws nothing
+	img = uniformImg(200, 100, 3, color.Black)
+	img.DrawText("Nothing", TextOptions{Font: font, Size: 16, Color: color.Transparent})
+	assert.True(t, inkBounds(img).Empty())
+}
+
+func TestDrawTextClipped(t *testing.T) {
+	opts := TextOptions{Font: testFont(), Size: 24, Color: color.White}
+
+	// only the part of the mask on the image is rasterized
+	_, mask := renderText("Hello", opts, image.Pt(20, 10))
+	assert.True(t, mask.Rect.Dx() <= 20)
+	assert.True(t, mask.Rect.Dy() <= 10)
+
+	img := uniformImg(20, 10, 3, color.Black)
+	assert.Nil(t, img.DrawText("Hello", opts))
+	assert.False(t, inkBounds(img).Empty())
+
+	// text placed off the image draws nothing
+	opts.Gravity, opts.Offset = GravityNorthWest, image.Pt(-1000, 0)
+	_, mask = renderText("Hello", opts, image.Pt(200, 60))
+	assert.True(t, mask.Rect.Empty())
+
+	img = uniformImg(200, 60, 3, color.Black)
+	assert.Nil(t, img.DrawText("Hello", opts))
+	assert.True(t, inkBounds(img).Empty())
+}
+
+func TestDrawTextErrors(t *testing.T) {
+	img := uniformImg(20, 20, 3, color.Black)
+
+	assert.NotNil(t, img.DrawText("x", TextOptions{Size: 12}))
+	assert.NotNil(t, img.DrawText("x", TextOptions{Font: testFont()}))
+	assert.NotNil(t, img.DrawText("x", TextOptions{Font: testFont(), Size: 12, Align: "justify"}))
+	assert.NotNil(t, img.DrawText("x", TextOptions{Font: testFont(), Size: 12, Gravity: "up"}))
+	assert.Nil(t, img.DrawText("", TextOptions{Font: testFont(), Size: 12}))
+
+	_, err := ParseFont([]byte("not a font"))
+	assert.NotNil(t, err)
+
+	img.Release()
+	assert.Equal(t, ErrReleased, img.DrawText("x", TextOptions{Font: testFont(), Size: 12}))
+}
+
+func BenchmarkDrawText(b *testing.B) {
+	img := testImg("lenna.jpg")
+	font := testFont()
+	opts := TextOptions{Font: font, Size: 32, Color: color.White, Gravity: GravitySouthEast, Offset: image.Pt(16, 16)}
+	for n := 0; n < b.N; n++ {
+		img.DrawText("© prism sample", opts)
+	}
+}
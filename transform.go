@@ -8,6 +8,8 @@ import "C"
 
 import (
 	"errors"
+	"fmt"
+	"image"
 	"runtime"
 	"unsafe"
 
@@ -15,151 +17,400 @@ import (
 )
 
 func (img *Image) Resize(width, height int) (err error) {
+	if err := checkSize(width, height); err != nil {
+		return err
+	}
+
 	img.m.Lock()
 	defer img.m.Unlock()
-	defer recoverWithError(&err)
 
-	resizedIplImg := allocTarget(img.iplImage, width, height)
+	if img.iplImage == nil {
+		return ErrReleased
+	}
+	return img.resize(width, height)
+}
 
-	C.cvResize(
-		unsafe.Pointer(img.iplImage),
-		unsafe.Pointer(resizedIplImg),
-		C.int(C.CV_INTER_AREA),
-	)
+func (img *Image) resize(width, height int) (err error) {
+	defer recoverWithError(&err)
 
-	C.cvReleaseImage(&img.iplImage)
-	img.iplImage = resizedIplImg
+	img.replace(resized(img.iplImage, width, height))
 
 	return nil
 }
 
 func (img *Image) Reorient() (err error) {
-	if img.exif == nil {
-		return nil
-	}
+	img.m.Lock()
+	defer img.m.Unlock()
 
-	orientation, err := img.exif.Get(exif.Orientation)
-	if err != nil {
-		return err
+	if img.iplImage == nil {
+		return ErrReleased
 	}
 
-	orientationValue, err := orientation.Int(0)
+	orientationValue, err := img.orientation()
 	if err != nil {
 		return err
 	}
 
 	switch orientationValue {
 	case 2:
-		err = img.FlipH()
+		err = img.mirror(1)
 	case 3:
-		err = img.Rotate180()
+		err = img.mirror(-1)
 	case 4:
-		err = img.FlipV()
+		err = img.mirror(0)
 	case 5:
-		if err = img.Rotate90(); err == nil {
-			err = img.FlipH()
+		if err = img.rotate90(); err == nil {
+			err = img.mirror(1)
 		}
 	case 6:
-		err = img.Rotate90()
+		err = img.rotate90()
 	case 7:
-		if err = img.Rotate270(); err == nil {
-			err = img.FlipH()
+		if err = img.rotate270(); err == nil {
+			err = img.mirror(1)
 		}
 	case 8:
-		err = img.Rotate270()
+		err = img.rotate270()
+	default:
+		img.detach()
 	}
 
 	return err
 }
 
 func (img *Image) Fit(width, height int) (err error) {
-	if width <= 0 && height <= 0 {
-		return
+	if width < 0 || height < 0 {
+		return fmt.Errorf("Invalid size: %dx%d", width, height)
 	}
 
-	bounds := img.Bounds()
-	srcW := bounds.Dx()
-	srcH := bounds.Dy()
+	img.m.Lock()
+	defer img.m.Unlock()
 
-	if srcW <= width && srcH <= height {
-		return
+	if img.iplImage == nil {
+		return ErrReleased
 	}
 
-	if width == 0 {
-		width = srcW
+	newW, newH, ok := fitSize(img.bounds(), width, height)
+	if !ok {
+		img.detach()
+		return nil
 	}
 
-	if height == 0 {
-		height = srcH
-	}
+	return img.resize(newW, newH)
+}
 
-	srcAspectRatio := float64(srcW) / float64(srcH)
-	maxAspectRatio := float64(width) / float64(height)
+// Crop discards the parts of img outside r, leaving bounds that start at
+// (0, 0)
+func (img *Image) Crop(r image.Rectangle) (err error) {
+	img.m.Lock()
+	defer img.m.Unlock()
+	defer recoverWithError(&err)
 
-	var newW, newH int
-	if srcAspectRatio > maxAspectRatio {
-		newW = width
-		newH = int(float64(newW) / srcAspectRatio)
-	} else {
-		newH = height
-		newW = int(float64(newH) * srcAspectRatio)
+	if img.iplImage == nil {
+		return ErrReleased
+	}
+
+	r, err = cropRect(img.bounds(), r)
+	if err != nil {
+		return err
 	}
 
-	return img.Resize(newW, newH)
+	img.replace(cropped(img.iplImage, r.Sub(img.origin)))
+
+	return nil
 }
 
 func (img *Image) Rotate90() (err error) {
 	img.m.Lock()
 	defer img.m.Unlock()
-	defer recoverWithError(&err)
 
-	newIplImg := allocTarget(img.iplImage, img.Bounds().Size().Y, img.Bounds().Size().X)
+	if img.iplImage == nil {
+		return ErrReleased
+	}
+	return img.rotate90()
+}
 
-	C.cvTranspose(
-		unsafe.Pointer(img.iplImage),
-		unsafe.Pointer(newIplImg),
-	)
+func (img *Image) rotate90() (err error) {
+	defer recoverWithError(&err)
 
-	C.cvReleaseImage(&img.iplImage)
-	img.iplImage = newIplImg
+	img.replace(transposed(img.iplImage, 1))
 
-	return flip(newIplImg, 1)
+	return nil
 }
 
 func (img *Image) Rotate180() error {
 	img.m.Lock()
 	defer img.m.Unlock()
-	return flip(img.iplImage, -1)
+
+	if img.iplImage == nil {
+		return ErrReleased
+	}
+	return img.mirror(-1)
 }
 
 func (img *Image) Rotate270() (err error) {
 	img.m.Lock()
 	defer img.m.Unlock()
-	defer recoverWithError(&err)
 
-	newIplImg := allocTarget(img.iplImage, img.Bounds().Size().Y, img.Bounds().Size().X)
+	if img.iplImage == nil {
+		return ErrReleased
+	}
+	return img.rotate270()
+}
 
-	C.cvTranspose(
-		unsafe.Pointer(img.iplImage),
-		unsafe.Pointer(newIplImg),
-	)
+func (img *Image) rotate270() (err error) {
+	defer recoverWithError(&err)
 
-	C.cvReleaseImage(&img.iplImage)
-	img.iplImage = newIplImg
+	img.replace(transposed(img.iplImage, 0))
 
-	return flip(newIplImg, 0)
+	return nil
 }
 
 func (img *Image) FlipH() error {
 	img.m.Lock()
 	defer img.m.Unlock()
-	return flip(img.iplImage, 1)
+
+	if img.iplImage == nil {
+		return ErrReleased
+	}
+	return img.mirror(1)
 }
 
 func (img *Image) FlipV() error {
 	img.m.Lock()
 	defer img.m.Unlock()
-	return flip(img.iplImage, 0)
+
+	if img.iplImage == nil {
+		return ErrReleased
+	}
+	return img.mirror(0)
+}
+
+// Non-mutating transforms
+//
+// These leave img untouched and return a new Image, allocated directly at the
+// target size, which the caller is responsible for releasing. They only take a
+// read lock, so several may run on the same source concurrently.
+
+// Resized returns a copy of img resized to width x height
+func (img *Image) Resized(width, height int) (*Image, error) {
+	if err := checkSize(width, height); err != nil {
+		return nil, err
+	}
+
+	return img.derive(func(src *C.IplImage) (*C.IplImage, error) {
+		return resized(src, width, height), nil
+	})
+}
+
+// Fitted returns a copy of img scaled down to fit within width x height,
+// preserving its aspect ratio
+func (img *Image) Fitted(width, height int) (*Image, error) {
+	if width < 0 || height < 0 {
+		return nil, fmt.Errorf("Invalid size: %dx%d", width, height)
+	}
+
+	return img.derive(func(src *C.IplImage) (*C.IplImage, error) {
+		newW, newH, ok := fitSize(img.bounds(), width, height)
+		if !ok {
+			return cloned(src), nil
+		}
+		return resized(src, newW, newH), nil
+	})
+}
+
+// Cropped returns a copy of the parts of img inside r. Unlike SubImage, the
+// result does not share pixels with img, and its bounds start at (0, 0).
+func (img *Image) Cropped(r image.Rectangle) (*Image, error) {
+	return img.derive(func(src *C.IplImage) (*C.IplImage, error) {
+		r, err := cropRect(img.bounds(), r)
+		if err != nil {
+			return nil, err
+		}
+		return cropped(src, r.Sub(img.origin)), nil
+	})
+}
+
+// Rotated90 returns a copy of img rotated 90 degrees clockwise
+func (img *Image) Rotated90() (*Image, error) {
+	return img.derive(func(src *C.IplImage) (*C.IplImage, error) {
+		return transposed(src, 1), nil
+	})
+}
+
+// Rotated180 returns a copy of img rotated 180 degrees
+func (img *Image) Rotated180() (*Image, error) {
+	return img.derive(func(src *C.IplImage) (*C.IplImage, error) {
+		return flipped(src, -1), nil
+	})
+}
+
+// Rotated270 returns a copy of img rotated 270 degrees clockwise
+func (img *Image) Rotated270() (*Image, error) {
+	return img.derive(func(src *C.IplImage) (*C.IplImage, error) {
+		return transposed(src, 0), nil
+	})
+}
+
+// FlippedH returns a copy of img mirrored horizontally
+func (img *Image) FlippedH() (*Image, error) {
+	return img.derive(func(src *C.IplImage) (*C.IplImage, error) {
+		return flipped(src, 1), nil
+	})
+}
+
+// FlippedV returns a copy of img mirrored vertically
+func (img *Image) FlippedV() (*Image, error) {
+	return img.derive(func(src *C.IplImage) (*C.IplImage, error) {
+		return flipped(src, 0), nil
+	})
+}
+
+// Reoriented returns a copy of img rotated and flipped upright according to
+// its EXIF orientation
+func (img *Image) Reoriented() (*Image, error) {
+	return img.derive(func(src *C.IplImage) (*C.IplImage, error) {
+		orientation, err := img.orientation()
+		if err != nil {
+			return nil, err
+		}
+
+		switch orientation {
+		case 2:
+			return flipped(src, 1), nil
+		case 3:
+			return flipped(src, -1), nil
+		case 4:
+			return flipped(src, 0), nil
+		case 5:
+			dst := transposed(src, 1)
+			return dst, flip(dst, 1)
+		case 6:
+			return transposed(src, 1), nil
+		case 7:
+			dst := transposed(src, 0)
+			return dst, flip(dst, 1)
+		case 8:
+			return transposed(src, 0), nil
+		}
+
+		return cloned(src), nil
+	})
+}
+
+// derive creates a new Image from img's pixels with fn, under a read lock
+func (img *Image) derive(fn func(src *C.IplImage) (*C.IplImage, error)) (derived *Image, err error) {
+	img.m.RLock()
+	defer img.m.RUnlock()
+	defer recoverWithError(&err)
+
+	if img.iplImage == nil {
+		return nil, ErrReleased
+	}
+
+	iplImage, err := fn(img.iplImage)
+	if err != nil {
+		if iplImage != nil {
+			C.cvReleaseImage(&iplImage)
+		}
+		return nil, err
+	}
+
+	return newImage(iplImage, img.exif), nil
+}
+
+// Orientation returns the EXIF orientation of img, from 1 (upright) to 8, or 1
+// if it is unknown
+func (img *Image) Orientation() int {
+	img.m.RLock()
+	defer img.m.RUnlock()
+
+	orientation, err := img.orientation()
+	if err != nil {
+		return 1
+	}
+	return orientation
+}
+
+// exif orientation of img, from 1 (upright) to 8
+func (img *Image) orientation() (int, error) {
+	return orientationOf(img.exif)
+}
+
+func orientationOf(meta *exif.Exif) (int, error) {
+	if meta == nil {
+		return 1, nil
+	}
+
+	orientation, err := meta.Get(exif.Orientation)
+	if err != nil {
+		return 0, err
+	}
+
+	return orientation.Int(0)
+}
+
+// size of the largest image with the aspect ratio of bounds fitting within
+// width x height, or ok == false if bounds already fit
+func fitSize(bounds image.Rectangle, width, height int) (newW, newH int, ok bool) {
+	if width <= 0 && height <= 0 {
+		return
+	}
+
+	srcW := bounds.Dx()
+	srcH := bounds.Dy()
+
+	if srcW <= width && srcH <= height {
+		return
+	}
+
+	if width == 0 {
+		width = srcW
+	}
+
+	if height == 0 {
+		height = srcH
+	}
+
+	srcAspectRatio := float64(srcW) / float64(srcH)
+	maxAspectRatio := float64(width) / float64(height)
+
+	if srcAspectRatio > maxAspectRatio {
+		newW = width
+		newH = int(float64(newW) / srcAspectRatio)
+	} else {
+		newH = height
+		newW = int(float64(newH) * srcAspectRatio)
+	}
+
+	// very wide or tall images keep at least a pixel across
+	if newW < 1 {
+		newW = 1
+	}
+	if newH < 1 {
+		newH = 1
+	}
+
+	return newW, newH, true
+}
+
+func cropRect(bounds, r image.Rectangle) (image.Rectangle, error) {
+	r = r.Intersect(bounds)
+	if r.Empty() {
+		return r, errors.New("Crop rectangle does not overlap image")
+	}
+	return r, nil
+}
+
+// mirror flips img about axis in place, or a view into a flipped copy, so
+// that its parent is untouched
+func (img *Image) mirror(axis int) (err error) {
+	if img.isView() {
+		defer recoverWithError(&err)
+
+		img.replace(flipped(img.iplImage, axis))
+		return nil
+	}
+	return flip(img.iplImage, axis)
 }
 
 func flip(iplImage *C.IplImage, axis int) (err error) {
@@ -174,6 +425,75 @@ func flip(iplImage *C.IplImage, axis int) (err error) {
 	return nil
 }
 
+func flipped(iplImage *C.IplImage, axis int) *C.IplImage {
+	size := C.cvGetSize(unsafe.Pointer(iplImage))
+	newIplImg := allocTarget(iplImage, int(size.width), int(size.height))
+
+	C.cvFlip(
+		unsafe.Pointer(iplImage),
+		unsafe.Pointer(newIplImg),
+		C.int(axis),
+	)
+
+	return newIplImg
+}
+
+// transpose into a new image, then flip around axis: 1 rotates 90 degrees
+// clockwise, 0 rotates 270 degrees
+func transposed(iplImage *C.IplImage, axis int) *C.IplImage {
+	size := C.cvGetSize(unsafe.Pointer(iplImage))
+	newIplImg := allocTarget(iplImage, int(size.height), int(size.width))
+
+	C.cvTranspose(
+		unsafe.Pointer(iplImage),
+		unsafe.Pointer(newIplImg),
+	)
+
+	C.cvFlip(
+		unsafe.Pointer(newIplImg),
+		unsafe.Pointer(newIplImg),
+		C.int(axis),
+	)
+
+	return newIplImg
+}
+
+// checkSize returns an error unless width and height are both positive, as
+// OpenCV requires of an image to resize to
+func checkSize(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("Invalid size: %dx%d", width, height)
+	}
+	return nil
+}
+
+func resized(iplImage *C.IplImage, width, height int) *C.IplImage {
+	resizedIplImg := allocTarget(iplImage, width, height)
+
+	C.cvResize(
+		unsafe.Pointer(iplImage),
+		unsafe.Pointer(resizedIplImg),
+		C.int(C.CV_INTER_AREA),
+	)
+
+	return resizedIplImg
+}
+
+// copy into a new image; unlike cvCloneImage, this is safe for sub-image
+// headers, whose last row is followed by pixels they do not own
+func cloned(iplImage *C.IplImage) *C.IplImage {
+	size := C.cvGetSize(unsafe.Pointer(iplImage))
+	newIplImg := allocTarget(iplImage, int(size.width), int(size.height))
+
+	C.cvCopy(unsafe.Pointer(iplImage), unsafe.Pointer(newIplImg), nil)
+
+	return newIplImg
+}
+
+func cropped(iplImage *C.IplImage, r image.Rectangle) *C.IplImage {
+	return C.prismCrop(iplImage, C.int(r.Min.X), C.int(r.Min.Y), C.int(r.Dx()), C.int(r.Dy()))
+}
+
 // create target image with new size, but same color depth and channels
 func allocTarget(iplImage *C.IplImage, width, height int) *C.IplImage {
 	size := C.CvSize{width: C.int(width), height: C.int(height)}
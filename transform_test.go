@@ -1,8 +1,11 @@
 package prism
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"fmt"
+	"image"
+	"image/jpeg"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -143,6 +146,128 @@ func TestFlipV(t *testing.T) {
 	assert.Equal(t, 504, img.Bounds().Dy())
 }
 
+func TestCrop(t *testing.T) {
+	img := testImg("mlk.png")
+	expected := img.At(110, 220)
+	_ = img.Crop(image.Rect(100, 200, 400, 1000))
+
+	assert.Equal(t, 300, img.Bounds().Dx())
+	assert.Equal(t, 304, img.Bounds().Dy())
+	assert.Equal(t, expected, img.At(10, 20))
+	assert.NotNil(t, img.Crop(image.Rect(1000, 1000, 1100, 1100)))
+}
+
+func TestResized(t *testing.T) {
+	img := testImg("mlk.png")
+	resized, err := img.Resized(100, 100)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "a8e50040e1a0219b3f2dd7710917f101048e071e", fmt.Sprintf("%x", sha1.Sum(resized.Bytes())))
+	assert.Equal(t, 525, img.Bounds().Dx())
+	assert.Equal(t, 504, img.Bounds().Dy())
+}
+
+func TestResizeInvalidSize(t *testing.T) {
+	img := testImg("mlk.png")
+
+	for _, size := range []image.Point{{0, 100}, {100, 0}, {-1, 100}, {100, -1}} {
+		resized, err := img.Resized(size.X, size.Y)
+		assert.Nil(t, resized)
+		assert.EqualError(t, err, fmt.Sprintf("Invalid size: %dx%d", size.X, size.Y))
+
+		err = img.Resize(size.X, size.Y)
+		assert.EqualError(t, err, fmt.Sprintf("Invalid size: %dx%d", size.X, size.Y))
+	}
+	assert.Equal(t, image.Rect(0, 0, 525, 504), img.Bounds())
+}
+
+func TestFitted(t *testing.T) {
+	img := testImg("mlk.png")
+	fitted, err := img.Fitted(100, 100)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "663fc1b903e0e4090f926687cc55c6829f57fa37", fmt.Sprintf("%x", sha1.Sum(fitted.Bytes())))
+	assert.Equal(t, 525, img.Bounds().Dx())
+
+	unchanged, _ := img.Fitted(1000, 1000)
+	assert.Equal(t, img.Bytes(), unchanged.Bytes())
+}
+
+func TestFittedExtremeAspect(t *testing.T) {
+	banner := uniformImg(1000, 10, 3, red)
+
+	fitted, err := banner.Fitted(32, 32)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 32, 1), fitted.Bounds())
+
+	fitted, err = uniformImg(10, 1000, 3, red).Fitted(32, 32)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 1, 32), fitted.Bounds())
+
+	b, err := Placeholder(banner, 24)
+	assert.Nil(t, err)
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Pt(24, 1), image.Pt(cfg.Width, cfg.Height))
+
+	hash, err := BlurHash(banner, 4, 3)
+	assert.Nil(t, err)
+	assert.Len(t, hash, 28)
+
+	_, err = banner.Fitted(-1, 32)
+	assert.EqualError(t, err, "Invalid size: -1x32")
+	assert.EqualError(t, banner.Fit(32, -1), "Invalid size: 32x-1")
+}
+
+func TestCropped(t *testing.T) {
+	img := testImg("mlk.png")
+	cropped, err := img.Cropped(image.Rect(100, 200, 400, 1000))
+
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 300, 304), cropped.Bounds())
+	assert.Equal(t, img.At(110, 220), cropped.At(10, 20))
+	assert.Equal(t, 525, img.Bounds().Dx())
+}
+
+func TestRotatedFlipped(t *testing.T) {
+	img := testImg("mlk.png")
+	expected := map[string]func() (*Image, error){
+		"26837accfb14ffb4c40e4ae431a832325d304791": img.Rotated90,
+		"017080651c5e51d8d07ea49de722b3f84f4b271f": img.Rotated180,
+		"302d7317bafe41022c7e0bf7020b417e586c527e": img.Rotated270,
+		"92dc081f5d70eb0d2d103419dec3d6ffb416ac44": img.FlippedH,
+		"3d756cbb38ec4327986d84e8971093cda2712e39": img.FlippedV,
+	}
+
+	for hash, transform := range expected {
+		transformed, err := transform()
+		assert.Nil(t, err)
+		assert.Equal(t, hash, fmt.Sprintf("%x", sha1.Sum(transformed.Bytes())))
+		transformed.Release()
+	}
+
+	assert.Equal(t, 525, img.Bounds().Dx())
+	assert.Equal(t, 504, img.Bounds().Dy())
+}
+
+func TestReoriented(t *testing.T) {
+	expected := map[string]string{
+		"orientations/orientation-5.jpg": "101f3dc5b9834dbf00db0f0f77f71233bb6defc5",
+		"orientations/orientation-6.jpg": "0abcaf927976b94134156e6c0385ea3262a66457",
+		"orientations/orientation-7.jpg": "bcf54553b6b7a0157caf25f56147256c75298706",
+	}
+
+	for name, hash := range expected {
+		img := testImg(name)
+		reoriented, err := img.Reoriented()
+
+		assert.Nil(t, err)
+		assert.Equal(t, hash, fmt.Sprintf("%x", sha1.Sum(reoriented.Bytes())), name)
+		assert.Equal(t, 480, reoriented.Bounds().Dx())
+		assert.Equal(t, 640, img.Bounds().Dx())
+	}
+}
+
 func BenchmarkResizeDown(b *testing.B) {
 	mlk := testImg("mlk.png")
 	b.ResetTimer()
@@ -230,3 +355,23 @@ func BenchmarkFlipV(b *testing.B) {
 		img.Release()
 	}
 }
+
+func BenchmarkResized(b *testing.B) {
+	mlk := testImg("mlk.png")
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		img, _ := mlk.Resized(100, 100)
+		img.Release()
+	}
+}
+
+func BenchmarkRotated90(b *testing.B) {
+	mlk := testImg("mlk.png")
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		img, _ := mlk.Rotated90()
+		img.Release()
+	}
+}